@@ -0,0 +1,121 @@
+package mercury
+
+import (
+	"crypto"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat is the format that entries are written in by a handler
+// created with AccessLog.
+type AccessLogFormat int
+
+const (
+	// AccessLogFormatText writes entries in a format similar to the Common Log
+	// Format, for example:
+	//
+	//	127.0.0.1:52814 - [17/Oct/2026:10:00:00 +0000] "gemini://example.com/" 20 "text/gemini" 1024 1.5ms
+	//
+	// The second field is the SHA-256 fingerprint of the client's certificate
+	// in hex, or a hyphen if no certificate was provided.
+	AccessLogFormatText AccessLogFormat = iota
+	// AccessLogFormatJSON writes each entry as a JSON object on its own line.
+	AccessLogFormatJSON
+)
+
+// AccessLogConfig controls the behaviour of a handler created with AccessLog.
+type AccessLogConfig struct {
+	// Output is where entries are written to. If this is nil, os.Stdout is
+	// used.
+	Output io.Writer
+	// Format is the format that entries are written in.
+	Format AccessLogFormat
+}
+
+type accessLogEntry struct {
+	Time                  time.Time `json:"time"`
+	RemoteAddress         string    `json:"remote_address"`
+	ClientCertFingerprint string    `json:"client_cert_fingerprint,omitempty"`
+	URL                   string    `json:"url"`
+	Status                Status    `json:"status"`
+	Meta                  string    `json:"meta"`
+	Size                  int64     `json:"size"`
+	LatencyMilliseconds   float64   `json:"latency_ms"`
+
+	latency time.Duration
+}
+
+// AccessLog creates a middleware function that writes an entry to a log for
+// every request once it has been served. Each entry contains the time the
+// request was received, the client's address and certificate fingerprint, the
+// request URL, the status and meta of the response, the number of bytes sent
+// to the client (including the response header) and how long the request
+// took to serve.
+//
+// The middleware should be registered before any other handlers, for example
+// by using (*App).Use.
+func AccessLog(conf AccessLogConfig) HandlerFunction {
+	if conf.Output == nil {
+		conf.Output = os.Stdout
+	}
+	mu := new(sync.Mutex)
+
+	return func(ctx *Ctx) error {
+		start := time.Now()
+
+		ctx.onComplete(func() {
+			latency := time.Since(start)
+			entry := &accessLogEntry{
+				Time:                start,
+				URL:                 ctx.originalURL.String(),
+				Status:              ctx.response.status,
+				Meta:                string(ctx.response.meta),
+				Size:                ctx.bytesSent,
+				LatencyMilliseconds: float64(latency) / float64(time.Millisecond),
+				latency:             latency,
+			}
+			if addr := ctx.GetRemoteAddress(); addr != nil {
+				entry.RemoteAddress = addr.String()
+			}
+			if certs := ctx.GetClientCertificates(); len(certs) != 0 {
+				entry.ClientCertFingerprint = hex.EncodeToString(FingerprintCertificateWithHash(certs[0], crypto.SHA256))
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			_ = writeAccessLogEntry(conf.Output, conf.Format, entry)
+		})
+
+		return ctx.Next()
+	}
+}
+
+func writeAccessLogEntry(w io.Writer, format AccessLogFormat, entry *accessLogEntry) error {
+	if format == AccessLogFormatJSON {
+		return json.NewEncoder(w).Encode(entry)
+	}
+
+	fingerprint := entry.ClientCertFingerprint
+	if fingerprint == "" {
+		fingerprint = "-"
+	}
+
+	_, err := fmt.Fprintf(
+		w,
+		"%s %s [%s] %q %d %q %d %s\n",
+		entry.RemoteAddress,
+		fingerprint,
+		entry.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		entry.URL,
+		entry.Status,
+		entry.Meta,
+		entry.Size,
+		entry.latency,
+	)
+	return err
+}
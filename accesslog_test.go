@@ -0,0 +1,61 @@
+package mercury
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"regexp"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	doRequest := func(t *testing.T, format AccessLogFormat) string {
+		buf := new(bytes.Buffer)
+		app := newTestApp(t)
+		app.Use(AccessLog(AccessLogConfig{Output: buf, Format: format}))
+		app.Add("/hello", func(ctx *Ctx) error {
+			ctx.SetBody("Hello world!")
+			return nil
+		})
+
+		_ = doTestRequest(t, app, func(conn net.Conn) error {
+			_, err := io.WriteString(conn, "gemini://localhost/hello\r\n")
+			return err
+		})
+		_ = doTestRequest(t, app, func(conn net.Conn) error {
+			_, err := io.WriteString(conn, "gemini://localhost/missing\r\n")
+			return err
+		})
+		return buf.String()
+	}
+
+	t.Run("text", func(t *testing.T) {
+		got := doRequest(t, AccessLogFormatText)
+		want := regexp.MustCompile(`^pipe - \[[^\]]+\] "gemini://localhost/hello" 20 "text/plain" 27 [0-9.]+[µnm]?s\n` +
+			`pipe - \[[^\]]+\] "gemini://localhost/missing" 51 "Not found" 14 [0-9.]+[µnm]?s\n$`)
+		if !want.MatchString(got) {
+			t.Errorf("log = %q, want match for %v", got, want)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		dec := json.NewDecoder(bytes.NewBufferString(doRequest(t, AccessLogFormatJSON)))
+		for _, want := range []accessLogEntry{
+			{RemoteAddress: "pipe", URL: "gemini://localhost/hello", Status: StatusSuccess, Meta: "text/plain", Size: 27},
+			{RemoteAddress: "pipe", URL: "gemini://localhost/missing", Status: StatusNotFound, Meta: "Not found", Size: 14},
+		} {
+			var got accessLogEntry
+			if err := dec.Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Time.IsZero() {
+				t.Error("entry has no timestamp")
+			}
+			got.Time, got.LatencyMilliseconds = want.Time, want.LatencyMilliseconds
+			if got != want {
+				t.Errorf("entry = %+v, want %+v", got, want)
+			}
+		}
+	})
+}
@@ -4,41 +4,95 @@ import (
 	"crypto/tls"
 	"errors"
 	"log"
+	"log/slog"
 	"time"
 )
 
 type AppConfigFunction func(*App) error
 
-// WithX509KeyPair loads an X509 certificate file and key file from disk.
+// WithX509KeyPair loads an X509 certificate file and key file from disk. This
+// certificate is used for any host that doesn't have its own certificate.
+//
+// Certificates loaded from disk can be reloaded without restarting the app
+// using (*App).ReloadCertificates or WithCertificateWatchInterval.
 func WithX509KeyPair(certFile, keyFile string) AppConfigFunction {
+	return WithHostX509KeyPair("", certFile, keyFile)
+}
+
+// WithX509KeyData loads an X509 certificate and key from the provided bytes.
+// This certificate is used for any host that doesn't have its own
+// certificate.
+func WithX509KeyData(certPEMBlock, keyPEMBlock []byte) AppConfigFunction {
+	return WithHostX509KeyData("", certPEMBlock, keyPEMBlock)
+}
+
+// WithHostX509KeyPair loads an X509 certificate file and key file from disk,
+// to be used when clients request the provided hostname using SNI. The
+// hostname may start with a wildcard label, such as *.example.com.
+func WithHostX509KeyPair(hostname, certFile, keyFile string) AppConfigFunction {
+	return func(app *App) error {
+		return app.certificates.loadFiles(hostname, certFile, keyFile)
+	}
+}
+
+// WithHostX509KeyData loads an X509 certificate and key from the provided
+// bytes, to be used when clients request the provided hostname using SNI. The
+// hostname may start with a wildcard label, such as *.example.com.
+func WithHostX509KeyData(hostname string, certPEMBlock, keyPEMBlock []byte) AppConfigFunction {
 	return func(app *App) error {
-		x, err := tls.LoadX509KeyPair(certFile, keyFile)
+		x, err := tls.X509KeyPair(certPEMBlock, keyPEMBlock)
 		if err != nil {
 			return err
 		}
-		app.certificate = x
+		app.certificates.set(hostname, &x)
 		return nil
 	}
 }
 
-// WithX509KeyData loads an X509 certificate and key from the provided bytes.
-func WithX509KeyData(certPEMBlock, keyPEMBlock []byte) AppConfigFunction {
+// WithCertificateWatchInterval makes the app check the files that certificates
+// were loaded from for changes at the provided interval while it's listening,
+// reloading any certificates that have changed. See (*App).ReloadCertificates.
+//
+// Setting this value to zero disables watching for changes.
+func WithCertificateWatchInterval(x time.Duration) AppConfigFunction {
 	return func(app *App) error {
-		x, err := tls.X509KeyPair(certPEMBlock, keyPEMBlock)
-		if err != nil {
-			return err
+		if x < 0 {
+			return errors.New("mercury: cannot have negative certificate watch interval")
 		}
-		app.certificate = x
+		app.certificateWatchInterval = x
 		return nil
 	}
 }
 
-// WithLogger sets the error logger to the one provided.
+// WithLogger sets the error logger to the one provided. Messages are prefixed
+// with "mercury: " and their attributes are written as key=value pairs.
 //
 // To disable logging, use this function with a nil logger.
 func WithLogger(x *log.Logger) AppConfigFunction {
 	return func(app *App) error {
-		app.logger = x
+		if x == nil {
+			app.logger = nil
+			return nil
+		}
+		app.logger = slog.New(newLogLoggerHandler(x))
+		return nil
+	}
+}
+
+// WithLogHandler sets the handler used for structured logging. Errors are
+// logged at slog.LevelError, and messages from debug mode are logged at
+// slog.LevelDebug. Where possible, messages have attributes containing the
+// client's address ("remote_addr"), the request URL ("url") and the error
+// that occurred ("error").
+//
+// To disable logging, use this function with a nil handler.
+func WithLogHandler(h slog.Handler) AppConfigFunction {
+	return func(app *App) error {
+		if h == nil {
+			app.logger = nil
+			return nil
+		}
+		app.logger = slog.New(h)
 		return nil
 	}
 }
@@ -57,7 +111,8 @@ func WithErrorHandler(eh ErrorHandlerFunction) AppConfigFunction {
 	}
 }
 
-// WithReadTimeout sets the read timeout for any incoming connections.
+// WithReadTimeout sets the read timeout for any incoming connections. It
+// applies to reading the request, before any handlers are run.
 //
 // Setting this value to zero disables read timeouts.
 func WithReadTimeout(x time.Duration) AppConfigFunction {
@@ -70,7 +125,10 @@ func WithReadTimeout(x time.Duration) AppConfigFunction {
 	}
 }
 
-// WithWriteTimeout sets the write timeout for any incoming connections.
+// WithWriteTimeout sets the write timeout for any incoming connections. The
+// context returned by (*Ctx).Context is cancelled if the response isn't ready
+// to be sent before the timeout passes. Streamed responses instead have the
+// timeout applied to each write.
 //
 // Setting this value to zero disables write timeouts.
 func WithWriteTimeout(x time.Duration) AppConfigFunction {
@@ -83,6 +141,23 @@ func WithWriteTimeout(x time.Duration) AppConfigFunction {
 	}
 }
 
+// WithHandlerTimeout sets the maximum amount of time that handlers can spend
+// serving a request, after which the context returned by (*Ctx).Context is
+// cancelled.
+//
+// Setting this value to zero disables handler timeouts.
+func WithHandlerTimeout(x time.Duration) AppConfigFunction {
+	return func(app *App) error {
+		if x < 0 {
+			return errors.New("mercury: cannot have negative handler timeout")
+		}
+		app.handlerTimeout = x
+		return nil
+	}
+}
+
+// WithDebugModeEnabled logs the content of every response sent by the app at
+// slog.LevelDebug.
 func WithDebugModeEnabled() AppConfigFunction {
 	return func(app *App) error {
 		app.debug = true
@@ -90,6 +165,16 @@ func WithDebugModeEnabled() AppConfigFunction {
 	}
 }
 
+// WithDisablePanicRecovery stops the app from recovering from panics in
+// handlers. By default, panics are logged and passed to the error handler as a
+// *PanicError.
+func WithDisablePanicRecovery() AppConfigFunction {
+	return func(app *App) error {
+		app.disablePanicRecovery = true
+		return nil
+	}
+}
+
 // WithDisableStartupMessage will disable the startup message printed to
 // os.Stderr on server start.
 func WithDisableStartupMessage() AppConfigFunction {
@@ -0,0 +1,215 @@
+package mercury
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errorNoCertificate = errors.New("mercury: no certificate available for requested host")
+
+// certificateStore holds the certificates used by an app and selects between
+// them based on the server name that a client requests.
+type certificateStore struct {
+	mu          sync.RWMutex
+	defaultCert *tls.Certificate
+	byHost      map[string]*tls.Certificate
+	// sources contains the files that certificates were loaded from, keyed
+	// by hostname
+	sources map[string]*certificateSource
+}
+
+// certificateSource records where a certificate was loaded from so that it
+// can be reloaded.
+type certificateSource struct {
+	certFile, keyFile       string
+	certModTime, keyModTime time.Time
+}
+
+func newCertificateStore() *certificateStore {
+	return &certificateStore{
+		byHost:  make(map[string]*tls.Certificate),
+		sources: make(map[string]*certificateSource),
+	}
+}
+
+// set stores a certificate for the provided hostname, or as the default
+// certificate if the hostname is empty.
+func (s *certificateStore) set(hostname string, cert *tls.Certificate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setLocked(normaliseHostname(hostname), cert)
+	delete(s.sources, normaliseHostname(hostname))
+}
+
+func (s *certificateStore) setLocked(hostname string, cert *tls.Certificate) {
+	if hostname == "" {
+		s.defaultCert = cert
+		return
+	}
+	s.byHost[hostname] = cert
+}
+
+// loadFiles loads a certificate from disk and stores it for the provided
+// hostname, in the same way as set. The files are remembered so that the
+// certificate can be reloaded later.
+func (s *certificateStore) loadFiles(hostname, certFile, keyFile string) error {
+	source := &certificateSource{certFile: certFile, keyFile: keyFile}
+	source.certModTime, source.keyModTime = source.modTimes()
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hostname = normaliseHostname(hostname)
+	s.setLocked(hostname, &cert)
+	s.sources[hostname] = source
+	return nil
+}
+
+// reload reloads certificates that were loaded from files. If onlyChanged is
+// true, only certificates whose files have been modified since they were last
+// loaded are reloaded.
+//
+// If a certificate cannot be reloaded, the previous version is kept and an
+// error is included in the returned error. The returned slice contains the
+// hostnames of every certificate that was successfully reloaded.
+func (s *certificateStore) reload(onlyChanged bool) ([]string, error) {
+	s.mu.RLock()
+	sources := make(map[string]certificateSource, len(s.sources))
+	for hostname, source := range s.sources {
+		sources[hostname] = *source
+	}
+	s.mu.RUnlock()
+
+	var (
+		reloaded []string
+		errs     []error
+	)
+	for hostname, source := range sources {
+		certModTime, keyModTime := source.modTimes()
+		if onlyChanged && certModTime.Equal(source.certModTime) && keyModTime.Equal(source.keyModTime) {
+			continue
+		}
+
+		cert, err := tls.LoadX509KeyPair(source.certFile, source.keyFile)
+
+		s.mu.Lock()
+		if current, found := s.sources[hostname]; found && *current == source {
+			// The modification times are updated even if loading failed so
+			// that broken files aren't retried until they change again.
+			current.certModTime, current.keyModTime = certModTime, keyModTime
+			if err == nil {
+				s.setLocked(hostname, &cert)
+				reloaded = append(reloaded, hostname)
+			}
+		}
+		s.mu.Unlock()
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("mercury: could not reload certificate for host %#v: %w", hostname, err))
+		}
+	}
+
+	return reloaded, errors.Join(errs...)
+}
+
+// modTimes returns the modification times of the certificate and key files,
+// or zero times if they cannot be determined.
+func (source *certificateSource) modTimes() (cert, key time.Time) {
+	if info, err := os.Stat(source.certFile); err == nil {
+		cert = info.ModTime()
+	}
+	if info, err := os.Stat(source.keyFile); err == nil {
+		key = info.ModTime()
+	}
+	return
+}
+
+// getCertificate selects a certificate for a client. A certificate
+// registered for the exact server name the client requested is preferred,
+// followed by one registered for a wildcard matching it (eg. *.example.com),
+// followed by the default certificate.
+//
+// This is suitable for use as tls.Config.GetCertificate.
+func (s *certificateStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if hello.ServerName != "" {
+		name := normaliseHostname(hello.ServerName)
+		if cert, found := s.byHost[name]; found {
+			return cert, nil
+		}
+		if i := strings.IndexByte(name, '.'); i != -1 {
+			if cert, found := s.byHost["*"+name[i:]]; found {
+				return cert, nil
+			}
+		}
+	}
+
+	if s.defaultCert != nil {
+		return s.defaultCert, nil
+	}
+	return nil, errorNoCertificate
+}
+
+func normaliseHostname(hostname string) string {
+	return strings.TrimSuffix(strings.ToLower(hostname), ".")
+}
+
+// ReloadCertificates reloads every certificate that was loaded from files
+// using WithX509KeyPair or WithHostX509KeyPair. New connections use the new
+// certificates immediately.
+//
+// If a certificate cannot be reloaded, the failure is logged and the previous
+// version of the certificate continues to be used. The returned error contains
+// every failure.
+func (app *App) ReloadCertificates() error {
+	return app.reloadCertificates(false)
+}
+
+func (app *App) reloadCertificates(onlyChanged bool) error {
+	reloaded, err := app.certificates.reload(onlyChanged)
+	for _, hostname := range reloaded {
+		app.logInfo("reloaded certificate", "hostname", hostname)
+	}
+	if err != nil {
+		app.logError("could not reload certificates", "error", err)
+	}
+	return err
+}
+
+// watchCertificates reloads any certificates whose files change, checking at
+// the interval set with WithCertificateWatchInterval, until the returned
+// function is called. If no interval is set, nothing happens.
+func (app *App) watchCertificates() (stop func()) {
+	if app.certificateWatchInterval == 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(app.certificateWatchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				_ = app.reloadCertificates(true)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
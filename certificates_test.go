@@ -0,0 +1,159 @@
+package mercury
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_certificateStore_getCertificate(t *testing.T) {
+	defaultCert := &tls.Certificate{}
+	exampleCert := &tls.Certificate{}
+	wildcardCert := &tls.Certificate{}
+
+	s := newCertificateStore()
+	s.set("Example.com", exampleCert)
+	s.set("*.example.com", wildcardCert)
+
+	tests := []struct {
+		name        string
+		serverName  string
+		withDefault bool
+		want        *tls.Certificate
+		wantErr     bool
+	}{
+		{"exact", "example.com", false, exampleCert, false},
+		{"exactDifferentCase", "EXAMPLE.com.", false, exampleCert, false},
+		{"wildcard", "gemini.example.com", false, wildcardCert, false},
+		{"wildcardOnlyMatchesOneLabel", "a.b.example.com", false, nil, true},
+		{"noMatch", "example.org", false, nil, true},
+		{"noMatchWithDefault", "example.org", true, defaultCert, false},
+		{"noServerName", "", true, defaultCert, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.withDefault {
+				s.set("", defaultCert)
+				defer s.set("", nil)
+			}
+			got, err := s.getCertificate(&tls.ClientHelloInfo{ServerName: tt.serverName})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getCertificate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("getCertificate() got = %p, want %p", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApp_Listen_sni(t *testing.T) {
+	oneCert, oneKey := generateTestCertificatePEM(t, "one.example")
+	twoCert, twoKey := generateTestCertificatePEM(t, "two.example")
+	app := newTestApp(t,
+		WithX509KeyData(generateTestCertificatePEM(t, "default.example")),
+		WithHostX509KeyData("one.example", oneCert, oneKey),
+		WithHostX509KeyData("two.example", twoCert, twoKey),
+		WithDisableStartupMessage(),
+	)
+	addr := startTestListener(t, app)
+	defer app.Shutdown()
+
+	for serverName, want := range map[string]string{
+		"one.example":   "one.example",
+		"two.example":   "two.example",
+		"three.example": "default.example",
+	} {
+		t.Run(serverName, func(t *testing.T) {
+			conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if got := conn.ConnectionState().PeerCertificates[0].Subject.CommonName; got != want {
+				t.Errorf("certificate common name = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func writeTestCertificateFiles(t *testing.T, dir, commonName string, modTime time.Time) (certFile, keyFile string) {
+	t.Helper()
+	certPEM, keyPEM := generateTestCertificatePEM(t, commonName)
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	for name, content := range map[string][]byte{certFile: certPEM, keyFile: keyPEM} {
+		if err := os.WriteFile(name, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return
+}
+
+func currentCertificateCommonName(t *testing.T, app *App) string {
+	t.Helper()
+	cert, err := app.certificates.getCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Subject.CommonName
+}
+
+func TestApp_ReloadCertificates(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	certFile, keyFile := writeTestCertificateFiles(t, dir, "first.example", now)
+
+	app := newTestApp(t, WithX509KeyPair(certFile, keyFile))
+	if got := currentCertificateCommonName(t, app); got != "first.example" {
+		t.Fatalf("initial certificate = %v, want first.example", got)
+	}
+
+	writeTestCertificateFiles(t, dir, "second.example", now.Add(time.Second))
+	if err := app.ReloadCertificates(); err != nil {
+		t.Fatal(err)
+	}
+	if got := currentCertificateCommonName(t, app); got != "second.example" {
+		t.Errorf("reloaded certificate = %v, want second.example", got)
+	}
+
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.ReloadCertificates(); err == nil {
+		t.Error("ReloadCertificates() error = nil, want error for invalid certificate")
+	}
+	if got := currentCertificateCommonName(t, app); got != "second.example" {
+		t.Errorf("certificate after failed reload = %v, want second.example", got)
+	}
+}
+
+func TestWithCertificateWatchInterval(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	certFile, keyFile := writeTestCertificateFiles(t, dir, "first.example", now)
+
+	app := newTestApp(t, WithX509KeyPair(certFile, keyFile), WithCertificateWatchInterval(5*time.Millisecond), WithDisableStartupMessage())
+	_ = startTestListener(t, app)
+	defer app.Shutdown()
+
+	writeTestCertificateFiles(t, dir, "second.example", now.Add(time.Second))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for currentCertificateCommonName(t, app) != "second.example" {
+		if time.Now().After(deadline) {
+			t.Fatal("certificate was not reloaded after files changed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,296 @@
+// Package client implements a Gemini client.
+//
+// Server certificates are verified on a trust-on-first-use basis: the first
+// certificate seen for a host is stored in a KnownHosts store, and later
+// connections to that host are rejected if they present a different one.
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codemicro/mercury"
+)
+
+// DefaultMaxRedirects is the number of redirects followed by a Client whose
+// MaxRedirects is zero.
+const DefaultMaxRedirects = 5
+
+var (
+	// ErrTooManyRedirects is returned when a request is redirected more
+	// times than a Client allows.
+	ErrTooManyRedirects = errors.New("client: too many redirects")
+
+	errorUnsupportedScheme = errors.New("client: URL scheme must be gemini")
+)
+
+// Client makes requests to Gemini servers. The zero value is a usable client
+// that follows up to DefaultMaxRedirects redirects and remembers server
+// certificates in memory.
+//
+// A Client is safe for concurrent use.
+type Client struct {
+	// KnownHosts stores the certificates trusted for each host. If nil, an
+	// in-memory store is used for the lifetime of the Client.
+	KnownHosts KnownHosts
+
+	// GetClientCertificate returns the client certificate to present when
+	// requesting a URL, including URLs that are redirected to. If it is nil
+	// or returns a nil certificate, no certificate is presented.
+	GetClientCertificate func(u *url.URL) (*tls.Certificate, error)
+
+	// MaxRedirects is the maximum number of redirects followed for a single
+	// request. If zero, DefaultMaxRedirects is used. If negative, redirects
+	// are returned to the caller instead of being followed.
+	MaxRedirects int
+
+	// Timeout limits the time taken by a request, including connecting,
+	// following redirects and reading the response body. Zero means no
+	// timeout.
+	Timeout time.Duration
+
+	once              sync.Once
+	defaultKnownHosts KnownHosts
+}
+
+// Response is a response from a Gemini server.
+type Response struct {
+	Status mercury.Status
+	Meta   string
+
+	// Body streams the response body from the server. It is always non-nil,
+	// and is empty unless the status is in the success range. The caller
+	// must close it.
+	Body io.ReadCloser
+
+	// URL is the URL that was requested to get this response, which differs
+	// from the original URL if any redirects were followed.
+	URL *url.URL
+
+	// TLS describes the connection that the response was received on.
+	TLS *tls.ConnectionState
+}
+
+// MediaType parses the meta field of a response with a success status code.
+func (r *Response) MediaType() (mercury.MediaType, error) {
+	if r.Status/10 != 2 {
+		return mercury.MediaType{}, fmt.Errorf("client: response with status %d has no media type", r.Status)
+	}
+	return mercury.ParseMediaType(r.Meta)
+}
+
+// Get requests the provided URL.
+func (c *Client) Get(ctx context.Context, rawURL string) (*Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(ctx, u)
+}
+
+// Do requests the provided URL, following redirects as configured by
+// MaxRedirects. Redirects to URLs that don't use the gemini scheme are
+// returned rather than followed.
+func (c *Client) Do(ctx context.Context, u *url.URL) (*Response, error) {
+	var cancel context.CancelFunc = func() {}
+	if c.Timeout != 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+	}
+
+	maxRedirects := c.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = DefaultMaxRedirects
+	}
+
+	for redirects := 0; ; redirects++ {
+		resp, err := c.do(ctx, u)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		if resp.Status/10 != 3 || maxRedirects < 0 {
+			if b, ok := resp.Body.(*body); ok {
+				// the timeout also applies to reading the body
+				b.cancel = cancel
+			} else {
+				cancel()
+			}
+			return resp, nil
+		}
+
+		target, err := u.Parse(resp.Meta)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("client: invalid redirect target %#v: %w", resp.Meta, err)
+		}
+		if !strings.EqualFold(target.Scheme, "gemini") {
+			// the caller has to decide what to do with redirects to other
+			// protocols
+			cancel()
+			return resp, nil
+		}
+
+		if redirects == maxRedirects {
+			cancel()
+			return nil, ErrTooManyRedirects
+		}
+		u = target
+	}
+}
+
+// do makes a single request without following redirects.
+func (c *Client) do(ctx context.Context, u *url.URL) (*Response, error) {
+	if !strings.EqualFold(u.Scheme, "gemini") {
+		return nil, errorUnsupportedScheme
+	}
+
+	request, err := mercury.EncodeRequest(u)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := c.dial(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	// closing the connection interrupts any reads or writes in progress
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	closeConn := func() error {
+		stop()
+		return conn.Close()
+	}
+
+	if _, err := conn.Write(request); err != nil {
+		_ = closeConn()
+		return nil, contextError(ctx, err)
+	}
+
+	br := bufio.NewReader(conn)
+	status, meta, err := mercury.ReadResponseHeader(br)
+	if err != nil {
+		_ = closeConn()
+		return nil, contextError(ctx, err)
+	}
+
+	state := conn.ConnectionState()
+	resp := &Response{
+		Status: status,
+		Meta:   meta,
+		URL:    u,
+		TLS:    &state,
+	}
+
+	if status/10 == 2 {
+		resp.Body = &body{r: br, ctx: ctx, close: closeConn}
+	} else {
+		// only successful responses have a body, so there's nothing more
+		// to read
+		_ = closeConn()
+		resp.Body = io.NopCloser(strings.NewReader(""))
+	}
+
+	return resp, nil
+}
+
+func (c *Client) dial(ctx context.Context, u *url.URL) (*tls.Conn, error) {
+	hostname := u.Hostname()
+	port := u.Port()
+	if port == "" {
+		port = "1965"
+	}
+	address := net.JoinHostPort(hostname, port)
+
+	var clientCertificate *tls.Certificate
+	if c.GetClientCertificate != nil {
+		var err error
+		clientCertificate, err = c.GetClientCertificate(u)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	config := &tls.Config{
+		ServerName: hostname,
+		MinVersion: tls.VersionTLS12,
+		// certificates are verified against the known hosts instead of
+		// against certificate authorities
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			return verifyConnection(c.knownHosts(), address, hostname, state)
+		},
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if clientCertificate == nil {
+				return new(tls.Certificate), nil
+			}
+			return clientCertificate, nil
+		},
+	}
+
+	dialer := &tls.Dialer{Config: config}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*tls.Conn), nil
+}
+
+func (c *Client) knownHosts() KnownHosts {
+	if c.KnownHosts != nil {
+		return c.KnownHosts
+	}
+	c.once.Do(func() {
+		c.defaultKnownHosts = NewMemoryKnownHosts()
+	})
+	return c.defaultKnownHosts
+}
+
+// contextError returns the context's error instead of err if the context is
+// done, since in that case err is only caused by the connection being closed.
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// body is the body of a successful response, which closes the connection
+// that it's read from when closed.
+type body struct {
+	r     io.Reader
+	ctx   context.Context
+	close func() error
+	// cancel is called after closing, if set
+	cancel context.CancelFunc
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		err = contextError(b.ctx, err)
+	}
+	return n, err
+}
+
+func (b *body) Close() error {
+	b.closeOnce.Do(func() {
+		b.closeErr = b.close()
+		if b.cancel != nil {
+			b.cancel()
+		}
+	})
+	return b.closeErr
+}
@@ -0,0 +1,313 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/codemicro/mercury"
+)
+
+func newTestCertificate(t *testing.T, hostnames ...string) tls.Certificate {
+	t.Helper()
+	certPEM, keyPEM, err := mercury.GenerateSelfSignedCertificate(hostnames, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// startTestServer serves the app on a loopback address using a certificate
+// for 127.0.0.1, returning the address.
+func startTestServer(t *testing.T, app *mercury.App) string {
+	t.Helper()
+	return startTestServerWithCertificate(t, app, newTestCertificate(t, "127.0.0.1"))
+}
+
+// startTestServerWithCertificate serves the app on a loopback address using
+// the provided certificate, returning the address.
+func startTestServerWithCertificate(t *testing.T, app *mercury.App, cert tls.Certificate) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequestClientCert,
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- app.ServeTLS(listener, config) }()
+	t.Cleanup(func() {
+		_ = app.Shutdown()
+		_ = listener.Close()
+		<-served
+	})
+
+	return listener.Addr().String()
+}
+
+func newTestApp(t *testing.T) *mercury.App {
+	t.Helper()
+
+	app, err := mercury.New(mercury.WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.Add("/hello", func(ctx *mercury.Ctx) error {
+		ctx.SetBody("Hello world!")
+		return nil
+	})
+	app.Add("/redirect/:n", func(ctx *mercury.Ctx) error {
+		n, _ := strconv.Atoi(ctx.GetURLParam("n"))
+		if n == 0 {
+			return ctx.Redirect("/hello")
+		}
+		return ctx.Redirect(strconv.Itoa(n - 1))
+	})
+	app.Add("/elsewhere", func(ctx *mercury.Ctx) error {
+		return ctx.Redirect("https://example.com/")
+	})
+	app.Add("/whoami", func(ctx *mercury.Ctx) error {
+		certs := ctx.GetClientCertificates()
+		if len(certs) == 0 {
+			ctx.SetStatus(mercury.StatusClientCertificateRequired)
+			ctx.SetMeta("Certificate required")
+			return nil
+		}
+		ctx.SetBody(certs[0].Subject.CommonName)
+		return nil
+	})
+
+	return app
+}
+
+func TestClient_Get(t *testing.T) {
+	addr := startTestServer(t, newTestApp(t))
+	clientCert := newTestCertificate(t, "alice")
+
+	tests := []struct {
+		name       string
+		client     *Client
+		path       string
+		wantStatus mercury.Status
+		wantMeta   string
+		wantBody   string
+		wantPath   string
+		wantErr    error
+	}{
+		{"success", &Client{}, "/hello", mercury.StatusSuccess, "text/plain", "Hello world!", "/hello", nil},
+		{"notFound", &Client{}, "/nothing", mercury.StatusNotFound, "Not found", "", "/nothing", nil},
+		{"followsRedirects", &Client{}, "/redirect/4", mercury.StatusSuccess, "text/plain", "Hello world!", "/hello", nil},
+		{"tooManyRedirects", &Client{}, "/redirect/5", 0, "", "", "", ErrTooManyRedirects},
+		{"customMaxRedirects", &Client{MaxRedirects: 6}, "/redirect/5", mercury.StatusSuccess, "text/plain", "Hello world!", "/hello", nil},
+		{"redirectsDisabled", &Client{MaxRedirects: -1}, "/redirect/0", mercury.StatusTemporaryRedirect, "gemini://" + addr + "/hello", "", "/redirect/0", nil},
+		{"redirectToOtherScheme", &Client{}, "/elsewhere", mercury.StatusTemporaryRedirect, "https://example.com/", "", "/elsewhere", nil},
+		{"withoutCertificate", &Client{}, "/whoami", mercury.StatusClientCertificateRequired, "Certificate required", "", "/whoami", nil},
+		{"withCertificate", &Client{GetClientCertificate: func(*url.URL) (*tls.Certificate, error) {
+			return &clientCert, nil
+		}}, "/whoami", mercury.StatusSuccess, "text/plain", "alice", "/whoami", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := tt.client.Get(context.Background(), "gemini://"+addr+tt.path)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Status != tt.wantStatus || resp.Meta != tt.wantMeta || string(body) != tt.wantBody {
+				t.Errorf("Get() got = %d %q %q, want %d %q %q", resp.Status, resp.Meta, body, tt.wantStatus, tt.wantMeta, tt.wantBody)
+			}
+			if resp.URL.Path != tt.wantPath {
+				t.Errorf("Get() URL path = %q, want %q", resp.URL.Path, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestClient_knownHosts(t *testing.T) {
+	addr := startTestServer(t, newTestApp(t))
+	rawURL := "gemini://" + addr + "/hello"
+
+	t.Run("trustsOnFirstUse", func(t *testing.T) {
+		knownHosts := NewMemoryKnownHosts()
+		client := &Client{KnownHosts: knownHosts}
+
+		resp, err := client.Get(context.Background(), rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+
+		if _, found, _ := knownHosts.Lookup(addr); !found {
+			t.Fatal("certificate was not trusted")
+		}
+
+		resp, err = client.Get(context.Background(), rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+	})
+
+	t.Run("rejectsMismatch", func(t *testing.T) {
+		knownHosts := NewMemoryKnownHosts()
+		_ = knownHosts.Trust(addr, KnownHost{Fingerprint: "abcdef", Expires: time.Now().Add(time.Hour)})
+
+		_, err := (&Client{KnownHosts: knownHosts}).Get(context.Background(), rawURL)
+		var mismatchErr *CertificateMismatchError
+		if !errors.As(err, &mismatchErr) {
+			t.Fatalf("Get() error = %v, want a *CertificateMismatchError", err)
+		}
+		if mismatchErr.Expected != "abcdef" {
+			t.Errorf("expected fingerprint = %q, want %q", mismatchErr.Expected, "abcdef")
+		}
+	})
+
+	t.Run("replacesExpired", func(t *testing.T) {
+		knownHosts := NewMemoryKnownHosts()
+		_ = knownHosts.Trust(addr, KnownHost{Fingerprint: "abcdef", Expires: time.Now().Add(-time.Hour)})
+
+		resp, err := (&Client{KnownHosts: knownHosts}).Get(context.Background(), rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+
+		if known, _, _ := knownHosts.Lookup(addr); known.Fingerprint == "abcdef" {
+			t.Error("expired certificate was not replaced")
+		}
+	})
+}
+
+func TestClient_commonNameCertificate(t *testing.T) {
+	tests := []struct {
+		name       string
+		commonName string
+		wantErr    bool
+	}{
+		{"matching", "127.0.0.1", false},
+		{"otherHost", "example.com", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := startTestServerWithCertificate(t, newTestApp(t), newCommonNameCertificate(t, tt.commonName))
+
+			resp, err := (&Client{}).Get(context.Background(), "gemini://"+addr+"/hello")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+		})
+	}
+}
+
+// newCommonNameCertificate generates a self-signed certificate that only
+// names its host in the Common Name, without any Subject Alternative Names.
+func newCommonNameCertificate(t *testing.T, commonName string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestClient_streaming(t *testing.T) {
+	release := make(chan struct{})
+	app := newTestApp(t)
+	app.Add("/stream", func(ctx *mercury.Ctx) error {
+		w, err := ctx.Stream()
+		if err != nil {
+			return err
+		}
+		_, _ = w.Write([]byte("first"))
+		<-release
+		_, _ = w.Write([]byte("second"))
+		return nil
+	})
+	addr := startTestServer(t, app)
+
+	resp, err := (&Client{}).Get(context.Background(), "gemini://"+addr+"/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	buf := make([]byte, len("first"))
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "first" {
+		t.Errorf("first read = %q, want %q", buf, "first")
+	}
+
+	close(release)
+	rest, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "second" {
+		t.Errorf("rest of body = %q, want %q", rest, "second")
+	}
+}
+
+func TestClient_Timeout(t *testing.T) {
+	app := newTestApp(t)
+	app.Add("/slow", func(ctx *mercury.Ctx) error {
+		<-ctx.Context().Done()
+		return nil
+	})
+	addr := startTestServer(t, app)
+
+	start := time.Now()
+	_, err := (&Client{Timeout: 50 * time.Millisecond}).Get(context.Background(), "gemini://"+addr+"/slow")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Get() took %v to time out", elapsed)
+	}
+}
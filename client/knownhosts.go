@@ -0,0 +1,196 @@
+package client
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codemicro/mercury"
+)
+
+// KnownHost is a certificate trusted for a host.
+type KnownHost struct {
+	// Fingerprint is the hex-encoded SHA-256 hash of the certificate.
+	Fingerprint string
+	// Expires is when the certificate expires. Once it has, a different
+	// certificate is trusted on first use again.
+	Expires time.Time
+}
+
+// KnownHosts stores the certificates trusted for each host. Hosts are
+// identified by their address, including the port (eg. "example.com:1965").
+type KnownHosts interface {
+	// Lookup returns the certificate trusted for a host, and false if no
+	// certificate is trusted for it yet.
+	Lookup(host string) (KnownHost, bool, error)
+	// Trust stores the certificate trusted for a host, replacing any
+	// existing one.
+	Trust(host string, knownHost KnownHost) error
+}
+
+// CertificateMismatchError is returned when a server presents a certificate
+// other than the one trusted for it.
+type CertificateMismatchError struct {
+	Host     string
+	Expected string
+	Got      string
+}
+
+func (e *CertificateMismatchError) Error() string {
+	return fmt.Sprintf("client: certificate for %s has fingerprint %s, expected %s", e.Host, e.Got, e.Expected)
+}
+
+// verifyConnection verifies the server's certificate against the known
+// hosts, trusting it if no unexpired certificate is trusted for the host yet.
+func verifyConnection(knownHosts KnownHosts, host, hostname string, state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("client: server presented no certificate")
+	}
+	cert := state.PeerCertificates[0]
+	fingerprint := hex.EncodeToString(mercury.FingerprintCertificateWithHash(cert, crypto.SHA256))
+
+	known, found, err := knownHosts.Lookup(host)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if found && now.Before(known.Expires) {
+		if known.Fingerprint != fingerprint {
+			return &CertificateMismatchError{Host: host, Expected: known.Fingerprint, Got: fingerprint}
+		}
+		return nil
+	}
+
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return fmt.Errorf("client: certificate for %s is not valid at the current time", host)
+	}
+	if err := verifyHostname(cert, hostname); err != nil {
+		return err
+	}
+
+	return knownHosts.Trust(host, KnownHost{Fingerprint: fingerprint, Expires: cert.NotAfter})
+}
+
+// verifyHostname checks that cert is valid for hostname. Many Gemini servers
+// use certificates that only name the host in their Common Name, which
+// VerifyHostname ignores, so the Common Name is used instead when the
+// certificate has no Subject Alternative Names.
+func verifyHostname(cert *x509.Certificate, hostname string) error {
+	if len(cert.DNSNames) != 0 || len(cert.IPAddresses) != 0 {
+		return cert.VerifyHostname(hostname)
+	}
+	commonName := strings.TrimSuffix(cert.Subject.CommonName, ".")
+	if !strings.EqualFold(commonName, strings.TrimSuffix(hostname, ".")) {
+		return fmt.Errorf("client: certificate is valid for %s, not %s", cert.Subject.CommonName, hostname)
+	}
+	return nil
+}
+
+// MemoryKnownHosts is a KnownHosts store that is kept in memory.
+type MemoryKnownHosts struct {
+	mu    sync.RWMutex
+	hosts map[string]KnownHost
+}
+
+// NewMemoryKnownHosts creates an empty MemoryKnownHosts.
+func NewMemoryKnownHosts() *MemoryKnownHosts {
+	return &MemoryKnownHosts{hosts: make(map[string]KnownHost)}
+}
+
+func (m *MemoryKnownHosts) Lookup(host string) (KnownHost, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	known, found := m.hosts[strings.ToLower(host)]
+	return known, found, nil
+}
+
+func (m *MemoryKnownHosts) Trust(host string, knownHost KnownHost) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hosts[strings.ToLower(host)] = knownHost
+	return nil
+}
+
+// FileKnownHosts is a KnownHosts store that is persisted to a file. Each line
+// of the file has a host, a fingerprint and the certificate's expiry time as
+// a Unix timestamp, separated by spaces. When a host is trusted more than
+// once, the last line for it is used.
+type FileKnownHosts struct {
+	path   string
+	memory *MemoryKnownHosts
+	// mu serialises writes to the file
+	mu sync.Mutex
+}
+
+// LoadKnownHosts loads known hosts from the file at path. The file is created
+// when the first host is trusted if it doesn't exist yet.
+func LoadKnownHosts(path string) (*FileKnownHosts, error) {
+	k := &FileKnownHosts{path: path, memory: NewMemoryKnownHosts()}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return k, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("client: %s:%d: expected 3 fields, got %d", path, lineNumber, len(fields))
+		}
+		expires, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("client: %s:%d: invalid expiry time: %w", path, lineNumber, err)
+		}
+
+		_ = k.memory.Trust(fields[0], KnownHost{Fingerprint: fields[1], Expires: time.Unix(expires, 0)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func (k *FileKnownHosts) Lookup(host string) (KnownHost, bool, error) {
+	return k.memory.Lookup(host)
+}
+
+func (k *FileKnownHosts) Trust(host string, knownHost KnownHost) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	f, err := os.OpenFile(k.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%s %s %d\n", strings.ToLower(host), knownHost.Fingerprint, knownHost.Expires.Unix())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return k.memory.Trust(host, knownHost)
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadKnownHosts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	expires := time.Unix(time.Now().Add(time.Hour).Unix(), 0)
+
+	knownHosts, err := LoadKnownHosts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := knownHosts.Trust("Example.com:1965", KnownHost{Fingerprint: "abc", Expires: expires}); err != nil {
+		t.Fatal(err)
+	}
+	if err := knownHosts.Trust("example.com:1965", KnownHost{Fingerprint: "def", Expires: expires}); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := LoadKnownHosts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	known, found, err := reloaded.Lookup("example.com:1965")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Fatal("host not found after reloading")
+	}
+	if want := (KnownHost{Fingerprint: "def", Expires: expires}); known != want {
+		t.Errorf("Lookup() got = %v, want %v", known, want)
+	}
+
+	if _, found, _ := reloaded.Lookup("example.org:1965"); found {
+		t.Error("unknown host was found")
+	}
+}
+
+func TestLoadKnownHosts_malformed(t *testing.T) {
+	for name, content := range map[string]string{
+		"missingField":  "example.com:1965 abc\n",
+		"invalidExpiry": "example.com:1965 abc tomorrow\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "known_hosts")
+			if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadKnownHosts(path); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
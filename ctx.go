@@ -1,62 +1,149 @@
 package mercury
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"net"
 	"net/url"
 	"strings"
+
+	"github.com/codemicro/mercury/gemtext"
 )
 
 type Ctx struct {
-	tlsConn *tls.Conn
+	app  *App
+	conn net.Conn
+	// tlsState is nil if the connection doesn't use TLS
+	tlsState *tls.ConnectionState
+	context  context.Context
+	// cancelContext cancels context. It is nil if the Ctx isn't serving a
+	// connection.
+	cancelContext context.CancelFunc
+	// stopWriteTimeout stops the write timeout from cancelling context once
+	// the response is streamed. It is nil if there is no write timeout.
+	stopWriteTimeout func() bool
 
 	request  *request
-	response *response
+	response *Response
+	// originalURL is the URL requested by the client, which is unaffected by
+	// mounted apps changing request
+	originalURL *url.URL
+	// mountPrefix is the path that the currently running mounted app is
+	// mounted at, as requested by the client. It is empty outside of mounted
+	// apps.
+	mountPrefix string
+
+	// locals contains values set with SetLocal. It is nil until SetLocal is
+	// first called.
+	locals map[any]any
 
 	bodyBuilder *strings.Builder
+	// stream is set once the response header has been sent to the client
+	stream io.Writer
+	// bytesSent is the number of bytes of the response, including the
+	// header, that have been sent to the client
+	bytesSent int64
+	// completionHooks are run once the request has been fully served
+	completionHooks []func()
 
+	// callstack contains every handler that matches the request path
 	callstack []*handler
 	// stackPointer points to the current handler in use from callstack
 	stackPointer int
 }
 
-func newCtx(tlsConn *tls.Conn, callStack []*handler, req *request) *Ctx {
-	resp := &response{
+func newCtx(app *App, conn net.Conn, callStack []*handler, req *request) *Ctx {
+	resp := &Response{
 		status: StatusSuccess,
 		meta:   []byte("text/plain"),
 	}
 
+	var originalURL *url.URL
+	if req != nil {
+		originalURL = req.URL
+	}
+
+	var tlsState *tls.ConnectionState
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		tlsState = &state
+	}
+
 	return &Ctx{
-		tlsConn:   tlsConn,
-		request:   req,
-		response:  resp,
-		callstack: callStack,
+		app:         app,
+		originalURL: originalURL,
+		conn:        conn,
+		tlsState:    tlsState,
+		context:     context.Background(),
+		request:     req,
+		response:    resp,
+		callstack:   callStack,
+	}
+}
+
+// Context returns a context.Context for the request. It is cancelled when
+// reading from or writing to the connection fails, when the handler timeout
+// set with WithHandlerTimeout passes, or when the connection is forcibly
+// closed by (*App).ShutdownWithContext.
+//
+// It is also cancelled if the write timeout set with WithWriteTimeout passes
+// before the response is ready to be sent, in which case context.Cause
+// returns context.DeadlineExceeded. Once (*Ctx).Stream has been called, the
+// write timeout applies to each write instead, and no longer cancels the
+// context. The read timeout only applies to reading the request, which has
+// finished before any handlers run.
+//
+// A client closing its end of the connection after sending its request
+// doesn't cancel the context.
+func (ctx *Ctx) Context() context.Context {
+	return ctx.context
+}
+
+// SetLocal stores a value under the provided key for the duration of the
+// request, so that it can be retrieved by other handlers in the callstack using
+// (*Ctx).Locals or Local. Keys must be comparable.
+func (ctx *Ctx) SetLocal(key, value any) {
+	if ctx.locals == nil {
+		ctx.locals = make(map[any]any)
 	}
+	ctx.locals[key] = value
+}
+
+// Locals returns the value stored under the provided key using
+// (*Ctx).SetLocal, or nil if no value has been stored.
+func (ctx *Ctx) Locals(key any) any {
+	return ctx.locals[key]
+}
+
+// Local returns the value stored under the provided key using (*Ctx).SetLocal
+// as type T. If no value has been stored or the value is not of type T, the
+// zero value of T and false are returned.
+func Local[T any](ctx *Ctx, key any) (T, bool) {
+	v, ok := ctx.locals[key].(T)
+	return v, ok
 }
 
 // SetStatus sets the status code of the response.
 func (ctx *Ctx) SetStatus(status Status) {
-	ctx.response.status = status
+	ctx.response.SetStatus(status)
 }
 
 // SetMeta sets the meta field of the response. The meaning of this field
 // depends on the status code used in the response. By default, this is set to
 // "text/plain", which is suitable for use with a 20 status code.
 func (ctx *Ctx) SetMeta(meta string) error {
-	if len(meta) > 1024 {
-		return fmt.Errorf("mercury: meta too long (len %d > 1024)", len(meta))
-	}
-	ctx.response.meta = []byte(meta)
-	return nil
+	return ctx.response.SetMeta(meta)
 }
 
 // SetBody sets the response body to a single string. This will be overridden
 // if (*ctx).SetBodyBuilder is used.
 func (ctx *Ctx) SetBody(body string) {
-	ctx.response.content = []byte(body)
+	ctx.response.SetBody([]byte(body))
 }
 
 // SetBodyFromFile reads a file with the specified name and uses its contents
@@ -67,10 +154,58 @@ func (ctx *Ctx) SetBodyFromFile(filename string) error {
 	if err != nil {
 		return err
 	}
-	ctx.response.content = cont
+	ctx.response.SetBody(cont)
+	return nil
+}
+
+// SetBodyFromReader uses the contents of an io.Reader as the response body.
+// The reader is not read from until the response is sent, at which point its
+// contents are copied directly to the client. If the reader is also an
+// io.Closer, it will be closed once the response has been sent or the body is
+// replaced.
+//
+// This will be overridden if (*ctx).SetBodyBuilder is used.
+func (ctx *Ctx) SetBodyFromReader(r io.Reader) {
+	ctx.response.SetBodyReader(r)
+}
+
+// SetBodyFromFS opens the named file from the provided filesystem and uses its
+// contents as the response body, in the same way as (*ctx).SetBodyFromReader.
+// The meta field is set to a MIME type inferred from the file's extension.
+//
+// This will be overridden if (*ctx).SetBodyBuilder is used.
+func (ctx *Ctx) SetBodyFromFS(fsys fs.FS, name string) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	if info.IsDir() {
+		_ = f.Close()
+		return fmt.Errorf("mercury: %s is a directory", name)
+	}
+
+	if err := ctx.SetMeta(mimeTypeByExtension(name)); err != nil {
+		_ = f.Close()
+		return err
+	}
+	ctx.SetBodyFromReader(f)
 	return nil
 }
 
+// SetGemtext sets the response body to the rendered document and the meta
+// field to "text/gemini". This will be overridden if (*ctx).SetBodyBuilder is
+// used.
+func (ctx *Ctx) SetGemtext(doc gemtext.Document) {
+	ctx.response.meta = []byte("text/gemini")
+	ctx.response.SetBody(doc.Bytes())
+}
+
 // SetBodyBuilder allows a strings.Builder to be used to create the response
 // body. This will overwrite any other calls made to set the response body.
 //
@@ -80,7 +215,67 @@ func (ctx *Ctx) SetBodyBuilder(sb *strings.Builder) {
 	ctx.bodyBuilder = sb
 }
 
+// Stream sends the response header to the client immediately and returns a
+// writer that sends data straight to the client as it is written, instead of
+// buffering the response body until every handler has returned. This is only
+// possible for responses with a success status code.
+//
+// Once Stream has been called, the status code, meta field and any body set
+// using other functions are ignored, and any error returned from a handler
+// closes the connection instead of being passed to the error handler. If the
+// app has a write timeout, it applies to each write made to the stream
+// instead of to the whole response, and stops bounding (*Ctx).Context.
+//
+// Calling Stream more than once returns the same writer.
+func (ctx *Ctx) Stream() (io.Writer, error) {
+	if ctx.stream != nil {
+		return ctx.stream, nil
+	}
+
+	if ctx.response.status/10 != 2 {
+		return nil, errorImpossibleResponse
+	}
+
+	header, err := ctx.response.encodeHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx.stopWriteTimeout != nil {
+		ctx.stopWriteTimeout()
+	}
+
+	w := &connWriter{app: ctx.app, conn: ctx.conn, bytesSent: &ctx.bytesSent, cancel: ctx.cancelContext}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	ctx.stream = w
+
+	return w, nil
+}
+
+// IsStreaming returns true if (*ctx).Stream has been called and the response
+// header has already been sent.
+func (ctx *Ctx) IsStreaming() bool {
+	return ctx.stream != nil
+}
+
+// onComplete registers a function to be run once the response has been sent
+// to the client and the connection has been closed. Functions are run in the
+// reverse order that they were registered in.
+func (ctx *Ctx) onComplete(f func()) {
+	ctx.completionHooks = append(ctx.completionHooks, f)
+}
+
+func (ctx *Ctx) runCompletionHooks() {
+	for i := len(ctx.completionHooks) - 1; i >= 0; i -= 1 {
+		ctx.completionHooks[i]()
+	}
+}
+
 // GetBody returns a pointer to the bytearray containing the response content.
+// This does not include any content set using (*ctx).SetBodyFromReader or
+// (*ctx).SetBodyFromFS.
 func (ctx *Ctx) GetBody() *[]byte {
 	return &ctx.response.content
 }
@@ -93,26 +288,30 @@ func (ctx *Ctx) GetMeta() *[]byte {
 
 // ClearBody empties the request body.
 func (ctx *Ctx) ClearBody() {
-	ctx.response.content = nil
+	ctx.response.SetBody(nil)
+}
+
+// Response returns the response that is sent once every handler has returned.
+// Middleware can use this to inspect or modify the response after calling
+// (*Ctx).Next. As with (*Ctx).SetBody, changes to the body are overridden if
+// (*Ctx).SetBodyBuilder is used.
+func (ctx *Ctx) Response() *Response {
+	return ctx.response
 }
 
 // Next executes the next handler in the callstack, or returns an error if one
 // doesn't exist.
 func (ctx *Ctx) Next() error {
-	for {
-		if ctx.stackPointer >= len(ctx.callstack) {
-			return NewError("Not found", StatusNotFound)
-		}
-		h := ctx.callstack[ctx.stackPointer]
-		ctx.stackPointer += 1
-		if doesHandlerMatchPath(ctx.request.pathComponents, h) {
-			e := h.f(ctx)
-			if ctx.bodyBuilder != nil {
-				ctx.SetBody(ctx.bodyBuilder.String())
-			}
-			return e
-		}
+	if ctx.stackPointer >= len(ctx.callstack) {
+		return NewError("Not found", StatusNotFound)
+	}
+	h := ctx.callstack[ctx.stackPointer]
+	ctx.stackPointer += 1
+	e := h.f(ctx)
+	if ctx.bodyBuilder != nil {
+		ctx.SetBody(ctx.bodyBuilder.String())
 	}
+	return e
 }
 
 func (ctx *Ctx) getHandler() *handler {
@@ -125,16 +324,39 @@ func (ctx *Ctx) getHandler() *handler {
 func (ctx *Ctx) GetURLParamWithDefault(name, defaultValue string) string {
 	h := ctx.getHandler()
 	for i, part := range h.pathComponents {
-		if !strings.HasPrefix(part, ":") {
+		if !(strings.HasPrefix(part, ":") || strings.HasPrefix(part, "*")) {
 			continue
 		}
-		if part[1:] == name {
-			return splitPath(ctx.request.URL.Path)[i]
+		if !strings.EqualFold(part[1:], name) {
+			continue
 		}
+
+		pathComponents := splitPath(ctx.request.URL.Path)
+		if part[0] == '*' {
+			if i >= len(pathComponents) {
+				return ""
+			}
+			return strings.Join(pathComponents[i:], "/")
+		}
+		return pathComponents[i]
 	}
 	return defaultValue
 }
 
+// getWildcardValue returns the contents of the current handler's wildcard URL
+// parameter, if it has one.
+func (ctx *Ctx) getWildcardValue() (string, bool) {
+	components := ctx.getHandler().pathComponents
+	if len(components) == 0 {
+		return "", false
+	}
+	last := components[len(components)-1]
+	if !strings.HasPrefix(last, "*") {
+		return "", false
+	}
+	return ctx.GetURLParam(last[1:]), true
+}
+
 // GetURLParam retrieves the contents of the named URL parameter in the request
 // URL. The parameter key is case-insensitive.
 //
@@ -142,6 +364,10 @@ func (ctx *Ctx) GetURLParamWithDefault(name, defaultValue string) string {
 // someone requested /hello/Abi, the result of calling GetURLParam("name")
 // would be "Abi".
 //
+// If a handler was registered with the path /files/*path and someone requested
+// /files/docs/index.gmi, the result of calling GetURLParam("path") would be
+// "docs/index.gmi".
+//
 // If the key is not recognised, an empty string is returned.
 func (ctx *Ctx) GetURLParam(name string) string {
 	return ctx.GetURLParamWithDefault(name, "")
@@ -166,12 +392,19 @@ func (ctx *Ctx) GetRawQuery() string {
 
 // GetClientCertificates retrieves the certificates provided to the server as
 // part of the Gemini request. Use these in order to identify a given client.
+//
+// If the request was served without TLS using (*App).ServeWithoutTLS, this
+// always returns nil.
 func (ctx *Ctx) GetClientCertificates() []*x509.Certificate {
-	return ctx.tlsConn.ConnectionState().PeerCertificates
+	if ctx.tlsState == nil {
+		return nil
+	}
+	return ctx.tlsState.PeerCertificates
 }
 
+// GetRemoteAddress returns the network address of the client.
 func (ctx *Ctx) GetRemoteAddress() net.Addr {
-	return ctx.tlsConn.RemoteAddr()
+	return ctx.conn.RemoteAddr()
 }
 
 // GetRequestURL returns the exact URL requested by the server.
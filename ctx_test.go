@@ -0,0 +1,217 @@
+package mercury
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/codemicro/mercury/gemtext"
+)
+
+func TestCtx_Locals(t *testing.T) {
+	type user struct{ name string }
+	type userKey struct{}
+
+	app := newTestApp(t)
+	app.Use(func(ctx *Ctx) error {
+		ctx.SetLocal(userKey{}, &user{name: "Abi"})
+		ctx.SetLocal("count", 3)
+		return ctx.Next()
+	})
+	app.Add("/", func(ctx *Ctx) error {
+		u, ok := Local[*user](ctx, userKey{})
+		if !ok || u.name != "Abi" {
+			t.Errorf("Local[*user]() = %v, %v, want user Abi", u, ok)
+		}
+
+		if got := ctx.Locals("count"); got != 3 {
+			t.Errorf("Locals() = %v, want 3", got)
+		}
+
+		if got, ok := Local[string](ctx, "count"); ok || got != "" {
+			t.Errorf("Local[string]() = %q, %v, want \"\", false", got, ok)
+		}
+
+		if got := ctx.Locals("missing"); got != nil {
+			t.Errorf("Locals() = %v, want nil", got)
+		}
+		return nil
+	})
+
+	ctx := newCtx(app, nil, app.router.match(splitPath("/")), nil)
+	if err := ctx.Next(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCtx_RequireInput(t *testing.T) {
+	app := newTestApp(t)
+	app.Add("/name", func(ctx *Ctx) error {
+		name, ok, err := ctx.RequireInput("What is your name?", func(input string) error {
+			if strings.TrimSpace(input) == "" {
+				return errors.New("Please enter a name")
+			}
+			return nil
+		})
+		if err != nil || !ok {
+			return err
+		}
+		ctx.SetBody("Hello " + name)
+		return nil
+	})
+	app.Add("/password", func(ctx *Ctx) error {
+		password, ok, err := ctx.RequireSensitiveInput("Password")
+		if err != nil || !ok {
+			return err
+		}
+		ctx.SetBody(password)
+		return nil
+	})
+
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"prompt", "/name", "10 What is your name?\r\n"},
+		{"decoded", "/name?Abi%20Smith", "20 text/plain\r\nHello Abi Smith"},
+		{"reprompt", "/name?%20", "10 Please enter a name\r\n"},
+		{"emptyReprompt", "/name?", "10 Please enter a name\r\n"},
+		{"invalidEncoding", "/name?%zz", "59 Invalid query string\r\n"},
+		{"sensitivePrompt", "/password", "11 Password\r\n"},
+		{"sensitive", "/password?hunter2", "20 text/plain\r\nhunter2"},
+		{"sensitiveEmpty", "/password?", "20 text/plain\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := doTestRequest(t, app, func(conn net.Conn) error {
+				_, err := io.WriteString(conn, "gemini://localhost"+tt.url+"\r\n")
+				return err
+			})
+			if got != tt.want {
+				t.Errorf("response = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCtx_Redirect(t *testing.T) {
+	sub := newTestApp(t)
+	sub.Add("/entry", func(ctx *Ctx) error {
+		return ctx.Redirect("entries")
+	})
+	sub.Redirect("/old", "/new")
+	sub.Redirect("/relative/from", "to?a=b")
+
+	app := newTestApp(t)
+	app.Add("/docs/old", func(ctx *Ctx) error {
+		return ctx.Redirect("new?a=b")
+	})
+	app.Add("/docs/moved", func(ctx *Ctx) error {
+		return ctx.PermanentRedirect("/elsewhere")
+	})
+	app.Add("/external", func(ctx *Ctx) error {
+		return ctx.Redirect("gemini://example.org/")
+	})
+	app.Add("/self", func(ctx *Ctx) error {
+		return ctx.Redirect("self")
+	})
+	app.Add("/long", func(ctx *Ctx) error {
+		return ctx.Redirect(strings.Repeat("a", 1024))
+	})
+	app.Mount("/guestbook", sub)
+	app.Redirect("/table/from", "to")
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"relative", "/docs/old", "30 gemini://localhost/docs/new?a=b\r\n"},
+		{"absolutePath", "/docs/moved", "31 gemini://localhost/elsewhere\r\n"},
+		{"otherHost", "/external", "30 gemini://example.org/\r\n"},
+		{"loop", "/self", "40 Internal server error\r\n"},
+		{"tooLong", "/long", "40 Internal server error\r\n"},
+		{"mounted", "/guestbook/entry", "30 gemini://localhost/guestbook/entries\r\n"},
+		{"table", "/table/from", "31 gemini://localhost/table/to\r\n"},
+		{"mountedTable", "/guestbook/old", "31 gemini://localhost/guestbook/new\r\n"},
+		{"mountedTableCase", "/GuestBook/old", "31 gemini://localhost/GuestBook/new\r\n"},
+		{"mountedTableRelative", "/guestbook/relative/from", "31 gemini://localhost/guestbook/relative/to?a=b\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := doTestRequest(t, app, func(conn net.Conn) error {
+				_, err := io.WriteString(conn, "gemini://localhost"+tt.path+"\r\n")
+				return err
+			})
+			if got != tt.want {
+				t.Errorf("response = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCtx_Response(t *testing.T) {
+	app := newTestApp(t)
+	app.Use(func(ctx *Ctx) error {
+		if err := ctx.Next(); err != nil {
+			return err
+		}
+
+		resp := ctx.Response()
+		if mediaType, err := resp.MediaType(); err == nil && mediaType.Type == "text/plain" {
+			resp.SetBody([]byte(strings.ToUpper(string(resp.Body()))))
+			return resp.SetMeta("text/plain; charset=utf-8")
+		}
+		return nil
+	})
+	app.Add("/hello", func(ctx *Ctx) error {
+		ctx.SetBody("hello world")
+		return nil
+	})
+	app.Add("/gemtext", func(ctx *Ctx) error {
+		ctx.SetBody("hello world")
+		return ctx.SetMeta("text/gemini")
+	})
+
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"rewritten", "/hello", "20 text/plain; charset=utf-8\r\nHELLO WORLD"},
+		{"untouched", "/gemtext", "20 text/gemini\r\nhello world"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := doTestRequest(t, app, func(conn net.Conn) error {
+				_, err := io.WriteString(conn, "gemini://localhost"+tt.url+"\r\n")
+				return err
+			})
+			if got != tt.want {
+				t.Errorf("response = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCtx_SetGemtext(t *testing.T) {
+	app := newTestApp(t)
+	app.Add("/", func(ctx *Ctx) error {
+		var b gemtext.Builder
+		b.Heading(1, "Hello")
+		b.Link("/world", "World")
+		ctx.SetGemtext(b.Document())
+		return nil
+	})
+
+	got := doTestRequest(t, app, func(conn net.Conn) error {
+		_, err := io.WriteString(conn, "gemini://localhost/\r\n")
+		return err
+	})
+	if want := "20 text/gemini\r\n# Hello\n=> /world World\n"; got != want {
+		t.Errorf("response = %q, want %q", got, want)
+	}
+}
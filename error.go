@@ -1,5 +1,7 @@
 package mercury
 
+import "fmt"
+
 type ErrorHandlerFunction func(ctx *Ctx, err error) error
 
 type Error struct {
@@ -19,6 +21,19 @@ func (e Error) Error() string {
 	return e.Message
 }
 
+// PanicError is passed to the error handler when a handler panics, unless panic
+// recovery has been disabled with WithDisablePanicRecovery.
+type PanicError struct {
+	// Value is the value that was passed to panic.
+	Value any
+	// Stack is the stack trace of the goroutine that panicked.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
 // DefaultErrorHandler is the error handler used when no other error handler
 // is set.
 func DefaultErrorHandler(ctx *Ctx, err error) error {
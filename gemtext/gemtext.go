@@ -0,0 +1,308 @@
+// Package gemtext provides a typed model of text/gemini documents.
+//
+// Documents can be built up line by line using a Builder, or written directly
+// as a Document. Either way, rendering escapes anything that would otherwise
+// change how a line is interpreted, so text taken from users or files can be
+// used safely.
+package gemtext
+
+import (
+	"io"
+	"strings"
+)
+
+// Line is a single line of a document, or a block of preformatted text. It is
+// implemented by Text, Link, Heading, ListItem, Quote and Preformatted.
+//
+// Lines returned by a Parser remember the source they were parsed from, and
+// are rendered exactly as it was unless they are modified.
+type Line interface {
+	// appendTo appends the rendered line to b, including the line ending.
+	appendTo(b []byte) []byte
+}
+
+// Text is a line of plain text. An empty Text is a blank line.
+type Text struct {
+	Text string
+
+	src *source[Text]
+}
+
+// Link is a link to another resource.
+type Link struct {
+	URL string
+	// Label is shown to the user instead of the URL if it isn't empty.
+	Label string
+
+	src *source[Link]
+}
+
+// Heading is a heading with a level from 1 to 3.
+type Heading struct {
+	Level int
+	Text  string
+
+	src *source[Heading]
+}
+
+// ListItem is an item in an unordered list.
+type ListItem struct {
+	Text string
+
+	src *source[ListItem]
+}
+
+// Quote is a quoted line.
+type Quote struct {
+	Text string
+
+	src *source[Quote]
+}
+
+// Preformatted is a block of preformatted text.
+type Preformatted struct {
+	// Alt is the alt text of the block, which may describe its contents.
+	Alt  string
+	Text string
+
+	src *source[Preformatted]
+	// unterminated is true if the block was parsed from the end of a
+	// document without being closed.
+	unterminated bool
+}
+
+// Document is a text/gemini document.
+type Document []Line
+
+// zeroWidthSpace is put at the start of lines that would otherwise be
+// interpreted as something other than what they are.
+const zeroWidthSpace = "\u200b"
+
+// lineBreaks replaces line breaks in text that has to fit on a single line.
+var lineBreaks = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// urlWhitespace encodes whitespace, which would end the URL of a link.
+var urlWhitespace = strings.NewReplacer(" ", "%20", "\t", "%09", "\r", "%0D", "\n", "%0A")
+
+func (l Text) appendTo(b []byte) []byte {
+	if l.src.matches(l) {
+		return append(b, l.src.raw...)
+	}
+	for _, line := range splitLines(l.Text) {
+		if isMarkup(line) {
+			b = append(b, zeroWidthSpace...)
+		}
+		b = append(b, line...)
+		b = append(b, '\n')
+	}
+	return b
+}
+
+func (l Link) appendTo(b []byte) []byte {
+	if l.src.matches(l) {
+		return append(b, l.src.raw...)
+	}
+	b = append(b, "=> "...)
+	b = append(b, urlWhitespace.Replace(l.URL)...)
+	if label := lineBreaks.Replace(l.Label); strings.TrimSpace(label) != "" {
+		b = append(b, ' ')
+		b = append(b, label...)
+	}
+	return append(b, '\n')
+}
+
+func (l Heading) appendTo(b []byte) []byte {
+	if l.src.matches(l) {
+		return append(b, l.src.raw...)
+	}
+	level := l.Level
+	if level < 1 {
+		level = 1
+	} else if level > 3 {
+		level = 3
+	}
+	b = append(b, strings.Repeat("#", level)...)
+	b = append(b, ' ')
+	b = append(b, lineBreaks.Replace(l.Text)...)
+	return append(b, '\n')
+}
+
+func (l ListItem) appendTo(b []byte) []byte {
+	if l.src.matches(l) {
+		return append(b, l.src.raw...)
+	}
+	b = append(b, "* "...)
+	b = append(b, lineBreaks.Replace(l.Text)...)
+	return append(b, '\n')
+}
+
+func (l Quote) appendTo(b []byte) []byte {
+	if l.src.matches(l) {
+		return append(b, l.src.raw...)
+	}
+	for _, line := range splitLines(l.Text) {
+		b = append(b, "> "...)
+		b = append(b, line...)
+		b = append(b, '\n')
+	}
+	return b
+}
+
+func (l Preformatted) appendTo(b []byte) []byte {
+	if l.src.matches(l) {
+		return append(b, l.src.raw...)
+	}
+	b = append(b, "```"...)
+	b = append(b, lineBreaks.Replace(l.Alt)...)
+	b = append(b, '\n')
+	if l.Text != "" {
+		for _, line := range splitLines(l.Text) {
+			if strings.HasPrefix(line, "```") {
+				// this would otherwise end the block
+				b = append(b, zeroWidthSpace...)
+			}
+			b = append(b, line...)
+			b = append(b, '\n')
+		}
+	}
+	return append(b, "```\n"...)
+}
+
+// source is the source that a line was parsed from, along with the line as it
+// was parsed. As long as a line is equal to what it was parsed as, the source
+// can be rendered in place of it.
+type source[T comparable] struct {
+	raw  string
+	line T
+}
+
+// newSource creates a source for a line parsed from raw. The line has to be
+// set once the source has been stored in it.
+func newSource[T comparable](raw string) *source[T] {
+	return &source[T]{raw: raw}
+}
+
+// matches reports whether l is unchanged from the parsed line. s may be nil
+// if l wasn't parsed.
+func (s *source[T]) matches(l T) bool {
+	return s != nil && s.line == l
+}
+
+// leftOpen reports whether rendering the block leaves it unclosed, which is
+// only the case if it is rendered as an unterminated block it was parsed from.
+func (l Preformatted) leftOpen() bool {
+	return l.unterminated && l.src.matches(l)
+}
+
+// splitLines splits text on any kind of line ending.
+func splitLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	return strings.Split(text, "\n")
+}
+
+// isMarkup reports whether a line of text would be interpreted as something
+// other than plain text.
+func isMarkup(line string) bool {
+	return strings.HasPrefix(line, "=>") ||
+		strings.HasPrefix(line, "#") ||
+		strings.HasPrefix(line, "* ") ||
+		strings.HasPrefix(line, ">") ||
+		strings.HasPrefix(line, "```")
+}
+
+// Bytes renders the document.
+func (d Document) Bytes() []byte {
+	var b []byte
+	var open bool
+	for _, line := range d {
+		if len(b) != 0 && b[len(b)-1] != '\n' {
+			// the last line of a parsed document may not have had a line
+			// ending
+			b = append(b, '\n')
+		}
+		if open {
+			// a block that was left open at the end of a parsed document
+			// would otherwise swallow the lines added after it
+			b = append(b, "```\n"...)
+		}
+		b = line.appendTo(b)
+		p, ok := line.(Preformatted)
+		open = ok && p.leftOpen()
+	}
+	return b
+}
+
+// String renders the document.
+func (d Document) String() string {
+	return string(d.Bytes())
+}
+
+// WriteTo renders the document to w.
+func (d Document) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(d.Bytes())
+	return int64(n), err
+}
+
+// Builder builds up a Document one line at a time. The zero value is ready
+// to use.
+type Builder struct {
+	doc Document
+}
+
+// Text adds plain text. Text containing line breaks is split into multiple
+// lines.
+func (b *Builder) Text(text string) {
+	for _, line := range splitLines(text) {
+		b.doc = append(b.doc, Text{Text: line})
+	}
+}
+
+// Blank adds a blank line.
+func (b *Builder) Blank() {
+	b.doc = append(b.doc, Text{})
+}
+
+// Link adds a link. The label may be empty.
+func (b *Builder) Link(url, label string) {
+	b.doc = append(b.doc, Link{URL: url, Label: label})
+}
+
+// Heading adds a heading. Levels outside of the range 1 to 3 are rendered as
+// the closest valid level.
+func (b *Builder) Heading(level int, text string) {
+	b.doc = append(b.doc, Heading{Level: level, Text: text})
+}
+
+// ListItem adds an item to an unordered list.
+func (b *Builder) ListItem(text string) {
+	b.doc = append(b.doc, ListItem{Text: text})
+}
+
+// Quote adds a quote. Quotes containing line breaks are split into multiple
+// lines.
+func (b *Builder) Quote(text string) {
+	for _, line := range splitLines(text) {
+		b.doc = append(b.doc, Quote{Text: line})
+	}
+}
+
+// Preformatted adds a block of preformatted text with the provided alt text.
+func (b *Builder) Preformatted(alt, text string) {
+	b.doc = append(b.doc, Preformatted{Alt: alt, Text: text})
+}
+
+// Add adds lines to the document as they are.
+func (b *Builder) Add(lines ...Line) {
+	b.doc = append(b.doc, lines...)
+}
+
+// Document returns the document that has been built.
+func (b *Builder) Document() Document {
+	return b.doc
+}
+
+// String renders the document that has been built.
+func (b *Builder) String() string {
+	return b.doc.String()
+}
@@ -0,0 +1,70 @@
+package gemtext
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDocument_String(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  Document
+		want string
+	}{
+		{"text", Document{Text{Text: "Hello world"}, Text{}}, "Hello world\n\n"},
+		{"multilineText", Document{Text{Text: "one\r\ntwo\nthree"}}, "one\ntwo\nthree\n"},
+		{"escapedText", Document{Text{Text: "# not a heading"}, Text{Text: "=> not a link"}, Text{Text: "* not a list"}, Text{Text: "> not a quote"}, Text{Text: "```not preformatted"}}, "\u200b# not a heading\n\u200b=> not a link\n\u200b* not a list\n\u200b> not a quote\n\u200b```not preformatted\n"},
+		{"unescapedText", Document{Text{Text: "*emphasis*"}, Text{Text: "a # b"}}, "*emphasis*\na # b\n"},
+		{"link", Document{Link{URL: "gemini://example.com/", Label: "Example"}}, "=> gemini://example.com/ Example\n"},
+		{"linkWithoutLabel", Document{Link{URL: "/about", Label: ""}}, "=> /about\n"},
+		{"linkWithWhitespace", Document{Link{URL: "/a b\tc", Label: "multi\nline"}}, "=> /a%20b%09c multi line\n"},
+		{"headings", Document{Heading{Level: 1, Text: "One"}, Heading{Level: 2, Text: "Two"}, Heading{Level: 3, Text: "Three"}}, "# One\n## Two\n### Three\n"},
+		{"headingLevelsClamped", Document{Heading{Level: 0, Text: "Zero"}, Heading{Level: 4, Text: "Four"}}, "# Zero\n### Four\n"},
+		{"listItem", Document{ListItem{Text: "first\nsecond"}}, "* first second\n"},
+		{"quote", Document{Quote{Text: "first\nsecond"}}, "> first\n> second\n"},
+		{"preformatted", Document{Preformatted{Alt: "go code", Text: "func main() {\n}"}}, "```go code\nfunc main() {\n}\n```\n"},
+		{"emptyPreformatted", Document{Preformatted{Alt: "", Text: ""}}, "```\n```\n"},
+		{"escapedPreformatted", Document{Preformatted{Alt: "md", Text: "```\nnested\n```"}}, "```md\n\u200b```\nnested\n\u200b```\n```\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.doc.String(); got != tt.want {
+				t.Errorf("String() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	var b Builder
+	b.Heading(1, "Guestbook")
+	b.Blank()
+	b.Text("Thanks for visiting!\n# Sign below")
+	b.Link("/sign", "Sign the guestbook")
+	b.ListItem("Abi")
+	b.Quote("Nice capsule\nSee you soon")
+	b.Preformatted("ascii art", ":)")
+	b.Add(Link{URL: "/"})
+
+	want := strings.Join([]string{
+		"# Guestbook",
+		"",
+		"Thanks for visiting!",
+		"\u200b# Sign below",
+		"=> /sign Sign the guestbook",
+		"* Abi",
+		"> Nice capsule",
+		"> See you soon",
+		"```ascii art",
+		":)",
+		"```",
+		"=> /",
+		"",
+	}, "\n")
+	if got := b.String(); got != want {
+		t.Errorf("String() got = %q, want %q", got, want)
+	}
+	if got := len(b.Document()); got != 10 {
+		t.Errorf("len(Document()) = %d, want 10", got)
+	}
+}
@@ -0,0 +1,137 @@
+package gemtext
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Parser reads a document one line at a time.
+type Parser struct {
+	r *bufio.Reader
+}
+
+// NewParser creates a Parser that reads a document from r.
+func NewParser(r io.Reader) *Parser {
+	return &Parser{r: bufio.NewReader(r)}
+}
+
+// Parse reads a whole document from r.
+func Parse(r io.Reader) (Document, error) {
+	p := NewParser(r)
+	var doc Document
+	for {
+		line, err := p.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return doc, nil
+			}
+			return nil, err
+		}
+		doc = append(doc, line)
+	}
+}
+
+// Next returns the next line of the document, or io.EOF once there are no
+// lines left. A block of preformatted text is returned as a single
+// Preformatted line, even if it isn't closed before the end of the document.
+func (p *Parser) Next() (Line, error) {
+	raw, err := p.readLine()
+	if err != nil {
+		return nil, err
+	}
+	content := trimLineEnding(raw)
+
+	if !strings.HasPrefix(content, "```") {
+		return parseLine(content, raw), nil
+	}
+
+	block := Preformatted{Alt: strings.TrimSpace(content[3:])}
+	var sb strings.Builder
+	sb.WriteString(raw)
+
+	var lines []string
+	for {
+		raw, err := p.readLine()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				block.unterminated = true
+				break
+			}
+			return nil, err
+		}
+		sb.WriteString(raw)
+
+		content := trimLineEnding(raw)
+		if strings.HasPrefix(content, "```") {
+			break
+		}
+		// undo the escaping done when rendering
+		if strings.HasPrefix(content, zeroWidthSpace+"```") {
+			content = content[len(zeroWidthSpace):]
+		}
+		lines = append(lines, content)
+	}
+
+	block.Text = strings.Join(lines, "\n")
+	block.src = newSource[Preformatted](sb.String())
+	block.src.line = block
+	return block, nil
+}
+
+// readLine reads a single line, including its line ending if it has one.
+func (p *Parser) readLine() (string, error) {
+	line, err := p.r.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", err
+	}
+	return line, nil
+}
+
+func trimLineEnding(line string) string {
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r")
+}
+
+// parseLine parses a line that isn't part of a preformatted block.
+func parseLine(content, raw string) Line {
+	switch {
+	case strings.HasPrefix(content, "=>"):
+		rest := strings.TrimLeft(content[2:], " \t")
+		url, label := rest, ""
+		if i := strings.IndexAny(rest, " \t"); i != -1 {
+			url, label = rest[:i], strings.TrimSpace(rest[i:])
+		}
+		l := Link{URL: url, Label: label, src: newSource[Link](raw)}
+		l.src.line = l
+		return l
+
+	case strings.HasPrefix(content, "#"):
+		level := 1
+		for level < 3 && strings.HasPrefix(content[level:], "#") {
+			level++
+		}
+		l := Heading{Level: level, Text: strings.TrimSpace(content[level:]), src: newSource[Heading](raw)}
+		l.src.line = l
+		return l
+
+	case strings.HasPrefix(content, "* "):
+		l := ListItem{Text: content[2:], src: newSource[ListItem](raw)}
+		l.src.line = l
+		return l
+
+	case strings.HasPrefix(content, ">"):
+		l := Quote{Text: strings.TrimLeft(content[1:], " \t"), src: newSource[Quote](raw)}
+		l.src.line = l
+		return l
+	}
+
+	// undo the escaping done when rendering
+	if text := strings.TrimPrefix(content, zeroWidthSpace); text != content && isMarkup(text) {
+		content = text
+	}
+	l := Text{Text: content, src: newSource[Text](raw)}
+	l.src.line = l
+	return l
+}
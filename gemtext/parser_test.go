@@ -0,0 +1,221 @@
+package gemtext
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// withoutSource returns a copy of doc with the source of each line removed, so
+// that it can be compared with a document that wasn't parsed.
+func withoutSource(doc Document) Document {
+	var stripped Document
+	for _, line := range doc {
+		switch l := line.(type) {
+		case Text:
+			l.src = nil
+			line = l
+		case Link:
+			l.src = nil
+			line = l
+		case Heading:
+			l.src = nil
+			line = l
+		case ListItem:
+			l.src = nil
+			line = l
+		case Quote:
+			l.src = nil
+			line = l
+		case Preformatted:
+			l.src, l.unterminated = nil, false
+			line = l
+		}
+		stripped = append(stripped, line)
+	}
+	return stripped
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Document
+	}{
+		{"empty", "", nil},
+		{"text", "Hello world\n\n", Document{Text{Text: "Hello world"}, Text{}}},
+		{"links", "=> /about\n=>gemini://example.com/\tExample  site \n=>  /a  b", Document{
+			Link{URL: "/about"},
+			Link{URL: "gemini://example.com/", Label: "Example  site"},
+			Link{URL: "/a", Label: "b"},
+		}},
+		{"headings", "# One\n##Two\n###   Three\n#### Four", Document{
+			Heading{Level: 1, Text: "One"},
+			Heading{Level: 2, Text: "Two"},
+			Heading{Level: 3, Text: "Three"},
+			Heading{Level: 3, Text: "# Four"},
+		}},
+		{"listItems", "* first\n*not a list item\n", Document{ListItem{Text: "first"}, Text{Text: "*not a list item"}}},
+		{"quotes", "> first\n>second\n", Document{Quote{Text: "first"}, Quote{Text: "second"}}},
+		{"preformatted", "```go code\nfunc main() {\n# not a heading\n}\n```\nafter\n", Document{
+			Preformatted{Alt: "go code", Text: "func main() {\n# not a heading\n}"},
+			Text{Text: "after"},
+		}},
+		{"unterminatedPreformatted", "```\none\ntwo", Document{Preformatted{Text: "one\ntwo"}}},
+		{"crlf", "# Title\r\ntext\r\n", Document{Heading{Level: 1, Text: "Title"}, Text{Text: "text"}}},
+		{"escaped", "\u200b# not a heading\n```\n\u200b```\n```\n", Document{
+			Text{Text: "# not a heading"},
+			Preformatted{Text: "```"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(iotest.OneByteReader(strings.NewReader(tt.input)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := withoutSource(got); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() got = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_roundTrip(t *testing.T) {
+	inputs := map[string]string{
+		"empty":                    "",
+		"simple":                   "# Title\n\nSome text\n=> /link Link\n* item\n> quote\n",
+		"noFinalLineEnding":        "# Title\ntext",
+		"crlf":                     "# Title\r\n\r\ntext\r\n=> /link\r\n",
+		"mixedLineEndings":         "one\r\ntwo\nthree\r\n",
+		"irregularWhitespace":      "#    Title   \n=>   /link    Label with  spaces  \n>   quote\n*  item \n",
+		"preformatted":             "```alt text  \n  indented\n\n# not a heading\n```   trailing\nafter\n",
+		"unterminatedPreformatted": "```\none\r\ntwo",
+		"emptyLink":                "=>\n=> \n",
+		"escaped":                  "\u200b# text\n```\n\u200b```\n```\n",
+		"bareCarriageReturn":       "one\rtwo\n",
+		"deepHeading":              "######\n",
+	}
+	for name, input := range inputs {
+		t.Run(name, func(t *testing.T) {
+			doc, err := Parse(strings.NewReader(input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := doc.String(); got != input {
+				t.Errorf("String() got = %q, want %q", got, input)
+			}
+		})
+	}
+}
+
+func TestParse_modified(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		modify  func(doc Document) Document
+		want    string
+		reparse Document
+	}{
+		{"editedLink", "#   Title\r\n=>  /old   Old\r\nlast line", func(doc Document) Document {
+			link := doc[1].(Link)
+			link.URL = "/new"
+			doc[1] = link
+			return append(doc, Text{Text: "appended"})
+		}, "#   Title\r\n=> /new Old\nlast line\nappended\n", Document{
+			Heading{Level: 1, Text: "Title"},
+			Link{URL: "/new", Label: "Old"},
+			Text{Text: "last line"},
+			Text{Text: "appended"},
+		}},
+		{"afterUnterminatedPreformatted", "```\ncode", func(doc Document) Document {
+			return append(doc, Link{URL: "/x", Label: "after"})
+		}, "```\ncode\n```\n=> /x after\n", Document{
+			Preformatted{Text: "code"},
+			Link{URL: "/x", Label: "after"},
+		}},
+		{"editedUnterminatedPreformatted", "```\ncode", func(doc Document) Document {
+			block := doc[0].(Preformatted)
+			block.Text = "new code"
+			doc[0] = block
+			return append(doc, Text{Text: "after"})
+		}, "```\nnew code\n```\nafter\n", Document{
+			Preformatted{Text: "new code"},
+			Text{Text: "after"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Parse(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := tt.modify(doc).String()
+			if got != tt.want {
+				t.Errorf("String() got = %q, want %q", got, tt.want)
+			}
+
+			reparsed, err := Parse(strings.NewReader(got))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reparsed := withoutSource(reparsed); !reflect.DeepEqual(reparsed, tt.reparse) {
+				t.Errorf("Parse() of output got = %#v, want %#v", reparsed, tt.reparse)
+			}
+		})
+	}
+}
+
+func TestParse_rendered(t *testing.T) {
+	var b Builder
+	b.Heading(2, "Heading")
+	b.Text("# escaped\nplain")
+	b.Link("/a b", "Label")
+	b.ListItem("item")
+	b.Quote("quote")
+	b.Preformatted("alt", "```\ncode")
+
+	doc, err := Parse(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := b.Document()
+	want[3] = Link{URL: "/a%20b", Label: "Label"}
+	if got := withoutSource(doc); !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() got = %#v, want %#v", got, want)
+	}
+}
+
+func FuzzParse_roundTrip(f *testing.F) {
+	f.Add("# Title\r\n=> /link Label\n```alt\ncode\n```\n* item\n> quote")
+	f.Add("\u200b# text\n```\n\u200b```")
+	f.Fuzz(func(t *testing.T, input string) {
+		doc, err := Parse(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := doc.String(); got != input {
+			t.Errorf("String() got = %q, want %q", got, input)
+		}
+	})
+}
+
+func TestParse_renderAllocations(t *testing.T) {
+	doc, err := Parse(strings.NewReader("# Title\n=> /link Label\n```\ncode\n```\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := make([]byte, 0, 1024)
+	allocs := testing.AllocsPerRun(100, func() {
+		for _, line := range doc {
+			b = line.appendTo(b[:0])
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("rendering unchanged lines made %v allocations, want 0", allocs)
+	}
+}
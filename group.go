@@ -0,0 +1,81 @@
+package mercury
+
+// Router is implemented by anything that handlers can be registered with.
+type Router interface {
+	Add(path string, handlerFunction HandlerFunction)
+	UseOnPath(path string, hf HandlerFunction)
+	Use(hf HandlerFunction)
+	Group(prefix string, middleware ...HandlerFunction) *Group
+	Mount(prefix string, subApp *App)
+	Redirect(from, to string)
+}
+
+var (
+	_ Router = (*App)(nil)
+	_ Router = (*Group)(nil)
+)
+
+// Group is a set of handlers that share a common path prefix and middleware.
+type Group struct {
+	router *router
+	prefix string
+}
+
+func newGroup(r *router, prefix string, middleware []HandlerFunction) *Group {
+	g := &Group{
+		router: r,
+		prefix: prefix,
+	}
+	for _, mw := range middleware {
+		g.Use(mw)
+	}
+	return g
+}
+
+// Group creates a new group of handlers under the provided path prefix. Any
+// middleware provided is used on every request under that prefix.
+func (app *App) Group(prefix string, middleware ...HandlerFunction) *Group {
+	return newGroup(app.router, joinPath("/", prefix), middleware)
+}
+
+// Host returns a group of handlers that are only used for requests whose URL
+// has the provided hostname. Handlers registered directly on the app are used
+// for requests to every host, including this one, in the order they were
+// registered in relative to the host's handlers.
+//
+// Calling Host more than once with the same hostname returns groups that
+// share the same set of handlers.
+func (app *App) Host(hostname string) *Group {
+	hostname = normaliseHostname(hostname)
+	r, found := app.hostRouters[hostname]
+	if !found {
+		r = app.router.newSibling()
+		app.hostRouters[hostname] = r
+	}
+	return newGroup(r, "/", nil)
+}
+
+// Add registers a handler function to be used to serve requests to a specific
+// URL, relative to the group's prefix. Paths are interpreted in the same way as
+// (*App).Add.
+func (g *Group) Add(path string, handlerFunction HandlerFunction) {
+	g.router.add(joinPath(g.prefix, path), handlerFunction, false)
+}
+
+// UseOnPath registers a middleware function to be used on any request whose
+// URL path starts with the provided path, relative to the group's prefix.
+func (g *Group) UseOnPath(path string, hf HandlerFunction) {
+	g.router.add(joinPath(g.prefix, path), hf, true)
+}
+
+// Use registers a middleware function to be used on every request under the
+// group's prefix.
+func (g *Group) Use(hf HandlerFunction) {
+	g.UseOnPath("/", hf)
+}
+
+// Group creates a new group nested inside this one. Middleware used on this
+// group also applies to the new group.
+func (g *Group) Group(prefix string, middleware ...HandlerFunction) *Group {
+	return newGroup(g.router, joinPath(g.prefix, prefix), middleware)
+}
@@ -0,0 +1,75 @@
+package mercury
+
+import "net/url"
+
+// InputValidator checks input provided by a client in response to an input
+// prompt. If the input is not acceptable, it should return an error, the
+// message of which is used to prompt the client for input again.
+type InputValidator func(input string) error
+
+// GetQueryWithDefault behaves identically to GetQuery, except it returns the
+// specified default value instead of an empty string if no query string was
+// provided.
+func (ctx *Ctx) GetQueryWithDefault(defaultValue string) (string, error) {
+	raw := ctx.GetRawQuery()
+	if raw == "" {
+		return defaultValue, nil
+	}
+	return url.PathUnescape(raw)
+}
+
+// GetQuery returns the percent-decoded query string from the request URL,
+// returning an empty string if there isn't one provided. Gemini clients send
+// the input provided in response to an input prompt as the query string.
+func (ctx *Ctx) GetQuery() (string, error) {
+	return ctx.GetQueryWithDefault("")
+}
+
+// RequireInput returns input provided by the client in response to an input
+// prompt.
+//
+// If the request URL has no query string, the response is set to ask the
+// client for input using the provided prompt, and ok is false. In this case,
+// the handler should return without doing anything else. Otherwise, the
+// decoded query string (which is empty if the URL ends in "?") is checked
+// using each of the validators in turn. If a validator returns an error, the
+// client is prompted again with the error's message and ok is false. If every
+// validator accepts the input, it is returned with ok set to true.
+func (ctx *Ctx) RequireInput(prompt string, validators ...InputValidator) (input string, ok bool, err error) {
+	return ctx.requireInput(StatusInput, prompt, validators)
+}
+
+// RequireSensitiveInput behaves identically to RequireInput, except it asks
+// the client for sensitive input (such as a password) that should not be
+// echoed to the screen as it is entered.
+func (ctx *Ctx) RequireSensitiveInput(prompt string, validators ...InputValidator) (input string, ok bool, err error) {
+	return ctx.requireInput(StatusSensitiveInput, prompt, validators)
+}
+
+func (ctx *Ctx) requireInput(status Status, prompt string, validators []InputValidator) (string, bool, error) {
+	if u := ctx.request.URL; u.RawQuery == "" && !u.ForceQuery {
+		return "", false, ctx.promptForInput(status, prompt)
+	}
+
+	input, err := ctx.GetQuery()
+	if err != nil {
+		return "", false, NewError("Invalid query string", StatusBadRequest)
+	}
+
+	for _, validator := range validators {
+		if err := validator(input); err != nil {
+			return "", false, ctx.promptForInput(status, err.Error())
+		}
+	}
+
+	return input, true, nil
+}
+
+func (ctx *Ctx) promptForInput(status Status, prompt string) error {
+	if err := ctx.SetMeta(prompt); err != nil {
+		return err
+	}
+	ctx.SetStatus(status)
+	ctx.ClearBody()
+	return nil
+}
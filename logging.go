@@ -0,0 +1,52 @@
+package mercury
+
+import (
+	"context"
+	"log"
+	"log/slog"
+)
+
+// newLogLoggerHandler creates an slog.Handler that writes to a *log.Logger,
+// for compatibility with WithLogger. Every level is enabled, and records are
+// written in the same format as slog.TextHandler without the time, since the
+// *log.Logger adds its own.
+func newLogLoggerHandler(logger *log.Logger) slog.Handler {
+	return slog.NewTextHandler(logLoggerWriter{logger}, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+}
+
+type logLoggerWriter struct {
+	logger *log.Logger
+}
+
+func (w logLoggerWriter) Write(p []byte) (int, error) {
+	if err := w.logger.Output(2, "mercury: "+string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (app *App) logError(msg string, args ...any) {
+	app.logAtLevel(slog.LevelError, msg, args...)
+}
+
+func (app *App) logInfo(msg string, args ...any) {
+	app.logAtLevel(slog.LevelInfo, msg, args...)
+}
+
+func (app *App) logDebug(msg string, args ...any) {
+	app.logAtLevel(slog.LevelDebug, msg, args...)
+}
+
+func (app *App) logAtLevel(level slog.Level, msg string, args ...any) {
+	if app.logger != nil {
+		app.logger.Log(context.Background(), level, msg, args...)
+	}
+}
@@ -0,0 +1,55 @@
+package mercury
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+)
+
+func doPanickingTestRequest(t *testing.T, conf AppConfigFunction) {
+	t.Helper()
+	app := newTestApp(t, conf)
+	app.Add("/", func(ctx *Ctx) error {
+		panic("oh no")
+	})
+	_ = doTestRequest(t, app, func(conn net.Conn) error {
+		_, err := io.WriteString(conn, "gemini://localhost/\r\n")
+		return err
+	})
+}
+
+func TestWithLogHandler(t *testing.T) {
+	buf := new(bytes.Buffer)
+	doPanickingTestRequest(t, WithLogHandler(slog.NewJSONHandler(buf, nil)))
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]any{
+		"level":       "ERROR",
+		"msg":         "recovered from panic",
+		"remote_addr": "pipe",
+		"url":         "gemini://localhost/",
+		"panic":       "oh no",
+	} {
+		if record[key] != want {
+			t.Errorf("record[%q] = %v, want %v", key, record[key], want)
+		}
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	doPanickingTestRequest(t, WithLogger(log.New(buf, "", 0)))
+
+	want := `mercury: level=ERROR msg="recovered from panic" remote_addr=pipe url=gemini://localhost/ panic="oh no" stack=`
+	if got := buf.String(); !strings.HasPrefix(got, want) {
+		t.Errorf("log = %q, want prefix %q", got, want)
+	}
+}
@@ -0,0 +1,81 @@
+package mercury
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// MediaType is the MIME type given in the meta field of a successful response.
+type MediaType struct {
+	// Type is the lower-case MIME type without any parameters (eg.
+	// "text/gemini").
+	Type string
+	// Charset is the value of the charset parameter. For text types, it
+	// defaults to "utf-8" if the parameter isn't given.
+	Charset string
+	// Lang is the value of the lang parameter, which is a comma-separated
+	// list of language tags. It is empty if the parameter isn't given.
+	Lang string
+	// Params contains every parameter, including charset and lang, with
+	// lower-case names.
+	Params map[string]string
+}
+
+// ParseMediaType parses the meta field of a successful response. An empty
+// meta field is treated as "text/gemini; charset=utf-8".
+func ParseMediaType(meta string) (MediaType, error) {
+	if strings.TrimSpace(meta) == "" {
+		meta = "text/gemini; charset=utf-8"
+	}
+
+	mediaType, params, err := mime.ParseMediaType(meta)
+	if errors.Is(err, mime.ErrInvalidMediaParameter) {
+		// Gemini allows lists of languages (eg. "lang=en,fr"), which
+		// aren't valid MIME parameter values without quotes
+		params, err = parseLenientMediaParams(meta)
+	}
+	if err != nil {
+		return MediaType{}, fmt.Errorf("mercury: invalid media type %#v: %w", meta, err)
+	}
+
+	m := MediaType{
+		Type:    mediaType,
+		Charset: strings.ToLower(params["charset"]),
+		Lang:    params["lang"],
+		Params:  params,
+	}
+	if m.Charset == "" && strings.HasPrefix(m.Type, "text/") {
+		m.Charset = "utf-8"
+	}
+
+	return m, nil
+}
+
+// parseLenientMediaParams parses the parameters of a media type, allowing any
+// character other than a semicolon in unquoted values.
+func parseLenientMediaParams(meta string) (map[string]string, error) {
+	params := make(map[string]string)
+	parts := strings.Split(meta, ";")
+	for _, part := range parts[1:] {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		key, value, found := strings.Cut(part, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if !found || key == "" {
+			return nil, mime.ErrInvalidMediaParameter
+		}
+		value = strings.TrimSpace(value)
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+
+		params[key] = value
+	}
+	return params, nil
+}
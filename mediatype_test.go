@@ -0,0 +1,32 @@
+package mercury
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMediaType(t *testing.T) {
+	tests := []struct {
+		name    string
+		meta    string
+		want    MediaType
+		wantErr bool
+	}{
+		{"empty", "", MediaType{"text/gemini", "utf-8", "", map[string]string{"charset": "utf-8"}}, false},
+		{"defaultCharset", "text/gemini", MediaType{"text/gemini", "utf-8", "", map[string]string{}}, false},
+		{"charsetAndLang", "Text/Gemini; Charset=ISO-8859-1; lang=en,fr", MediaType{"text/gemini", "iso-8859-1", "en,fr", map[string]string{"charset": "ISO-8859-1", "lang": "en,fr"}}, false},
+		{"nonText", "image/png", MediaType{"image/png", "", "", map[string]string{}}, false},
+		{"invalid", "text/gemini; charset", MediaType{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMediaType(tt.meta)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMediaType() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMediaType() got = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
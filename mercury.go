@@ -1,11 +1,16 @@
 package mercury
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net"
 	"os"
+	"runtime/debug"
 	"strings"
 	"sync"
 	"time"
@@ -19,31 +24,47 @@ type handler struct {
 	f              HandlerFunction
 	pathComponents []string
 	isMiddleware   bool
+	// order is the position the handler was registered in, relative to other
+	// handlers in the same router.
+	order int
 }
 
 type App struct {
 	// don't write to this stuff after a call to Listen
-	debug                 bool
-	certificate           tls.Certificate
-	logger                *log.Logger
-	callstack             []*handler
-	errorHandler          ErrorHandlerFunction
-	readTimeout           time.Duration
-	writeTimeout          time.Duration
-	disableStartupMessage bool
-	serverName            string
+	debug                    bool
+	certificates             *certificateStore
+	logger                   *slog.Logger
+	router                   *router
+	hostRouters              map[string]*router
+	errorHandler             ErrorHandlerFunction
+	readTimeout              time.Duration
+	writeTimeout             time.Duration
+	handlerTimeout           time.Duration
+	certificateWatchInterval time.Duration
+	disableStartupMessage    bool
+	disablePanicRecovery     bool
+	serverName               string
 
 	// thread-safe stuff
 	mu               *sync.Mutex
 	isListenerClosed bool
 	listener         net.Listener
+	activeConns      map[net.Conn]context.CancelFunc
 }
 
+// shutdownPollInterval is how often ShutdownWithContext checks for remaining
+// active connections.
+const shutdownPollInterval = 10 * time.Millisecond
+
 func New(conf ...AppConfigFunction) (*App, error) {
 	app := &App{
-		logger:       log.Default(),
+		logger:       slog.New(newLogLoggerHandler(log.Default())),
 		errorHandler: DefaultErrorHandler,
+		certificates: newCertificateStore(),
+		router:       newRouter(),
+		hostRouters:  make(map[string]*router),
 		mu:           new(sync.Mutex),
+		activeConns:  make(map[net.Conn]context.CancelFunc),
 	}
 
 	for _, f := range conf {
@@ -52,37 +73,30 @@ func New(conf ...AppConfigFunction) (*App, error) {
 		}
 	}
 
-	app.logger.SetPrefix("mercury: ")
-
 	return app, nil
 }
 
-func (app *App) log(format string, args ...any) {
-	if app.logger != nil {
-		app.logger.Printf(format, args...)
-	}
-}
-
 // Add registers a handler function to be used to serve requests to a specific
 // URL.
+//
+// Path components starting with a colon are URL parameters that match any
+// single component, such as /hello/:name. URL parameters at the end of a path
+// can be made optional with a trailing question mark, such as /wiki/:page?. A
+// final component starting with an asterisk matches the rest of the path,
+// such as /files/*path.
+//
+// Add panics if the path is invalid.
 func (app *App) Add(path string, handlerFunction HandlerFunction) {
-	if !strings.HasPrefix(path, "/") {
-		path = "/" + path
-	}
-	app.callstack = append(app.callstack, &handler{
-		f:              handlerFunction,
-		pathComponents: splitPath(strings.ToLower(path)),
-	})
+	app.router.add(path, handlerFunction, false)
 }
 
+// UseOnPath registers a middleware function to be used on any request whose
+// URL path starts with the provided path.
 func (app *App) UseOnPath(path string, hf HandlerFunction) {
-	app.callstack = append(app.callstack, &handler{
-		f:              hf,
-		pathComponents: splitPath(path),
-		isMiddleware:   true,
-	})
+	app.router.add(path, hf, true)
 }
 
+// Use registers a middleware function to be used on every request.
 func (app *App) Use(hf HandlerFunction) {
 	app.UseOnPath("/", hf)
 }
@@ -98,19 +112,74 @@ func (app *App) Listen(addr string) error {
 		}
 	}
 
-	app.mu.Lock()
-	listener, err := tls.Listen("tcp", addr, &tls.Config{
-		Certificates: []tls.Certificate{app.certificate},
-		ServerName:   app.serverName,
-		ClientAuth:   tls.RequestClientCert,
-		MinVersion:   tls.VersionTLS12,
-	})
-	app.listener = listener
-	app.mu.Unlock()
+	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
 	}
 
+	return app.Serve(listener)
+}
+
+// Serve serves Gemini requests on connections accepted from the provided
+// listener, using TLS with the app's certificates. Connections that already
+// use TLS, such as those from a listener created with tls.NewListener, are
+// used as they are. This can be used to serve on listeners passed in by
+// systemd socket activation, on Unix sockets or on in-memory listeners.
+//
+// Serve returns nil once the app is shut down, or an error if the listener is
+// closed by anything else.
+func (app *App) Serve(listener net.Listener) error {
+	config := app.tlsConfig()
+	return app.serve(listener, func(conn net.Conn) net.Conn {
+		if _, ok := conn.(*tls.Conn); ok {
+			return conn
+		}
+		return tls.Server(conn, config)
+	})
+}
+
+// ServeTLS behaves identically to Serve, except that it always wraps
+// connections using the provided TLS configuration. If the configuration has
+// no certificates, the app's certificates are used. A nil configuration uses
+// the same configuration as Serve.
+//
+// The configuration is otherwise used as it is, so client certificates are
+// only requested if ClientAuth is set. To change some settings while keeping
+// the rest of the app's defaults, start from (*App).TLSConfig.
+func (app *App) ServeTLS(listener net.Listener, config *tls.Config) error {
+	if config == nil {
+		config = app.tlsConfig()
+	} else if len(config.Certificates) == 0 && config.GetCertificate == nil {
+		config = config.Clone()
+		config.GetCertificate = app.certificates.getCertificate
+	}
+	return app.serve(listener, func(conn net.Conn) net.Conn {
+		return tls.Server(conn, config)
+	})
+}
+
+// ServeWithoutTLS serves Gemini requests on connections accepted from the
+// provided listener without using TLS. This is only useful when running
+// behind a relay that terminates TLS itself, since Gemini clients always use
+// TLS. (*Ctx).GetClientCertificates always returns nil for requests served
+// this way.
+func (app *App) ServeWithoutTLS(listener net.Listener) error {
+	return app.serve(listener, func(conn net.Conn) net.Conn {
+		return conn
+	})
+}
+
+// serve accepts connections from the listener until the app is shut down,
+// wrapping each one before processing it.
+func (app *App) serve(listener net.Listener, wrap func(net.Conn) net.Conn) error {
+	app.mu.Lock()
+	app.listener = listener
+	app.isListenerClosed = false
+	app.mu.Unlock()
+
+	stopWatchingCertificates := app.watchCertificates()
+	defer stopWatchingCertificates()
+
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
@@ -122,65 +191,207 @@ func (app *App) Listen(addr string) error {
 				break
 			}
 
-			app.log("error when accepting connection: %v", err)
+			if errors.Is(err, net.ErrClosed) {
+				// the listener was closed by something other than Shutdown
+				return err
+			}
+
+			app.logError("error when accepting connection", "error", err)
+			continue
+		}
+		conn = wrap(conn)
+
+		connContext, cancel := context.WithCancel(context.Background())
+		if !app.trackConn(conn, cancel) {
+			// shutdown started between accepting the connection and now
+			cancel()
+			_ = conn.Close()
 			continue
 		}
 
-		go app.processConn(conn)
+		go func() {
+			defer app.untrackConn(conn)
+			app.processConn(connContext, conn)
+		}()
 	}
 
 	return nil
 }
 
-func (app *App) processConn(conn net.Conn) {
-	tlsConn, ok := conn.(*tls.Conn)
-	if !ok {
-		app.log("got non-TLS connection")
-		_ = conn.Close()
-		return
+// TLSConfig returns a copy of the TLS configuration used by Serve, which
+// requests client certificates and uses the app's certificates. It can be
+// modified and passed to (*App).ServeTLS.
+func (app *App) TLSConfig() *tls.Config {
+	return app.tlsConfig()
+}
+
+// tlsConfig returns the TLS configuration used to serve connections.
+func (app *App) tlsConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: app.certificates.getCertificate,
+		ServerName:     app.serverName,
+		ClientAuth:     tls.RequestClientCert,
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// match returns the callstack to use for a request.
+func (app *App) match(req *request) []*handler {
+	if r, found := app.hostRouters[normaliseHostname(req.URL.Hostname())]; found {
+		return matchRouters(req.pathComponents, app.router, r)
 	}
+	return app.router.match(req.pathComponents)
+}
+
+// processConn serves a single request from a connection. connContext should
+// be cancelled if the connection is closed by the server.
+func (app *App) processConn(connContext context.Context, conn net.Conn) {
+	reqContext, cancelRequest := context.WithCancel(connContext)
+	defer cancelRequest()
 
 	if app.readTimeout != 0 {
-		_ = tlsConn.SetReadDeadline(time.Now().Add(app.readTimeout))
+		_ = conn.SetReadDeadline(time.Now().Add(app.readTimeout))
 	}
 
 	if app.writeTimeout != 0 {
-		_ = tlsConn.SetWriteDeadline(time.Now().Add(app.writeTimeout))
+		// this covers writes made during the TLS handshake, and is pushed
+		// back whenever the response is written
+		_ = conn.SetWriteDeadline(time.Now().Add(app.writeTimeout))
 	}
 
-	requestBytes := make([]byte, 1026) // Maximum length request URL + CRLF = 1026 bytes
-	if _, err := tlsConn.Read(requestBytes); err != nil {
-		app.log("could not read request: %v", err)
-		_ = tlsConn.Close()
+	requestBytes, err := readRequest(conn)
+	if err != nil {
+		var e *Error
+		if errors.As(err, &e) {
+			_ = app.callErrorHandler(conn, nil, err)
+			return
+		}
+		app.logError("could not read request", "remote_addr", conn.RemoteAddr().String(), "error", err)
+		_ = conn.Close()
 		return
 	}
 
 	parsedRequest, err := parseRequest(requestBytes)
 	if err != nil {
-		_ = app.callErrorHandler(tlsConn, nil, err)
+		_ = app.callErrorHandler(conn, nil, err)
 		return // when ctx == nil in callErrorHandler, the connection is always closed for us.
 	}
 
-	ctx := newCtx(tlsConn, app.callstack, parsedRequest)
+	// The read timeout only applies to reading the request. After that,
+	// reading is only used to find out when the client disconnects.
+	_ = conn.SetReadDeadline(time.Time{})
+	go watchForDisconnect(conn, cancelRequest)
+
+	if app.handlerTimeout != 0 {
+		var cancel context.CancelFunc
+		reqContext, cancel = context.WithTimeout(reqContext, app.handlerTimeout)
+		defer cancel()
+	}
+
+	var stopWriteTimeout func() bool
+	if app.writeTimeout != 0 {
+		// A buffered response has to be ready before the write timeout
+		// passes. Streamed responses stop this timer, since the write
+		// timeout applies to each of their writes instead.
+		var cancel context.CancelCauseFunc
+		reqContext, cancel = context.WithCancelCause(reqContext)
+		defer cancel(nil)
+		timer := time.AfterFunc(app.writeTimeout, func() { cancel(context.DeadlineExceeded) })
+		defer timer.Stop()
+		stopWriteTimeout = timer.Stop
+	}
 
-	if err := ctx.Next(); err != nil {
-		if requestClosed := app.callErrorHandler(tlsConn, ctx, err); requestClosed {
+	ctx := newCtx(app, conn, app.match(parsedRequest), parsedRequest)
+	ctx.context = reqContext
+	ctx.cancelContext = cancelRequest
+	ctx.stopWriteTimeout = stopWriteTimeout
+	defer ctx.response.closeBodyReader()
+	defer ctx.runCompletionHooks()
+
+	if err := app.runCallstack(ctx); err != nil {
+		if requestClosed := app.callErrorHandler(conn, ctx, err); requestClosed {
 			return
 		}
 	}
 
+	if ctx.IsStreaming() {
+		_ = conn.Close()
+		return
+	}
+
 	respBytes, err := ctx.response.Encode()
 	if err != nil {
-		if requestClosed := app.callErrorHandler(tlsConn, ctx, err); requestClosed {
+		if requestClosed := app.callErrorHandler(conn, ctx, err); requestClosed {
 			return
 		}
 	}
 
-	app.writeToConn(tlsConn, respBytes)
-	_ = tlsConn.Close()
+	ctx.bytesSent += int64(app.writeToConn(conn, respBytes))
+	if ctx.response.bodyReader != nil {
+		w := &connWriter{app: app, conn: conn, bytesSent: &ctx.bytesSent, cancel: cancelRequest}
+		if _, err := io.Copy(w, ctx.response.bodyReader); err != nil {
+			app.logError("could not send response body", "remote_addr", conn.RemoteAddr().String(), "url", parsedRequest.URL.String(), "error", err)
+		}
+	}
+	_ = conn.Close()
+}
+
+// trackConn records a connection as active, returning false if the app is
+// shutting down and the connection should not be served. cancel is called if
+// the connection is closed by ShutdownWithContext.
+func (app *App) trackConn(conn net.Conn, cancel context.CancelFunc) bool {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	if app.isListenerClosed {
+		return false
+	}
+	app.activeConns[conn] = cancel
+	return true
+}
+
+func (app *App) untrackConn(conn net.Conn) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	delete(app.activeConns, conn)
+}
+
+// runCallstack runs the handlers in the callstack of ctx. Unless panic recovery
+// is disabled, any panic is recovered from and returned as a *PanicError.
+func (app *App) runCallstack(ctx *Ctx) (err error) {
+	if !app.disablePanicRecovery {
+		defer func() {
+			if r := recover(); r != nil {
+				stack := debug.Stack()
+				app.logError("recovered from panic", "remote_addr", ctx.GetRemoteAddress().String(), "url", ctx.originalURL.String(), "panic", r, "stack", string(stack))
+				err = &PanicError{Value: r, Stack: stack}
+			}
+		}()
+	}
+	return ctx.Next()
+}
+
+// watchForDisconnect reads from a connection until reading from it fails, then
+// calls cancel. Anything that's read is discarded.
+//
+// io.EOF doesn't cancel anything, since clients are allowed to close their end
+// of the connection as soon as they've sent a request. A client that has gone
+// away completely is instead detected when writing the response fails.
+func watchForDisconnect(conn net.Conn, cancel context.CancelFunc) {
+	buf := make([]byte, 64)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			if !errors.Is(err, io.EOF) {
+				cancel()
+			}
+			return
+		}
+	}
 }
 
-// Shutdown shuts down the app if it's listening
+// Shutdown shuts down the app if it's listening. Connections that are already
+// being served are left to finish in the background - use ShutdownWithContext
+// to wait for them.
 func (app *App) Shutdown() error {
 	app.mu.Lock()
 	defer app.mu.Unlock()
@@ -197,16 +408,69 @@ func (app *App) Shutdown() error {
 	return nil
 }
 
+// ShutdownWithContext stops the app from accepting new connections, then waits
+// for every connection that's already being served to finish.
+//
+// If the context is cancelled before that happens, any remaining connections
+// are closed, the contexts of the requests being served on them are cancelled,
+// and ShutdownWithContext returns the number of connections that were closed
+// along with the context's error.
+func (app *App) ShutdownWithContext(ctx context.Context) (int, error) {
+	if err := app.Shutdown(); err != nil {
+		return 0, err
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		app.mu.Lock()
+		numActive := len(app.activeConns)
+		app.mu.Unlock()
+
+		if numActive == 0 {
+			return 0, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return app.closeActiveConns(), ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeActiveConns closes every active connection, returning the number of
+// connections that were closed.
+func (app *App) closeActiveConns() int {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	for conn, cancel := range app.activeConns {
+		cancel()
+		_ = conn.Close()
+	}
+	return len(app.activeConns)
+}
+
 // callErrorHandler will always close the request if no ctx is provided, else
 // the connection may or may not be closed.
-func (app *App) callErrorHandler(conn *tls.Conn, ctx *Ctx, err error) (connClosed bool) {
+func (app *App) callErrorHandler(conn net.Conn, ctx *Ctx, err error) (connClosed bool) {
 	ctxWasProvided := ctx != nil
 	if !ctxWasProvided {
-		ctx = newCtx(conn, nil, nil)
+		ctx = newCtx(app, conn, nil, nil)
+	}
+
+	if ctx.IsStreaming() {
+		// the response header has already been sent, so there's no way to
+		// tell the client that anything's gone wrong
+		app.logError("error after response header was sent", "remote_addr", conn.RemoteAddr().String(), "url", ctx.originalURL.String(), "error", err)
+		_ = conn.Close()
+		return true
 	}
 
 	if err2 := app.errorHandler(ctx, err); err2 != nil {
-		app.log("error handler returned error '%v' when handling error '%v'", err2, err)
+		app.logError("error handler returned an error", "remote_addr", conn.RemoteAddr().String(), "error", err2, "handled_error", err)
 		_ = conn.Close()
 		return true
 	}
@@ -224,9 +488,43 @@ func (app *App) callErrorHandler(conn *tls.Conn, ctx *Ctx, err error) (connClose
 	return false
 }
 
-func (app *App) writeToConn(tls *tls.Conn, content []byte) {
+// writeToConn writes content to a connection, returning the number of bytes
+// written.
+func (app *App) writeToConn(conn net.Conn, content []byte) int {
+	if app.writeTimeout != 0 {
+		_ = conn.SetWriteDeadline(time.Now().Add(app.writeTimeout))
+	}
 	if app.debug {
-		app.logger.Printf("sending response with content %#v", string(content))
+		app.logDebug("sending response", "remote_addr", conn.RemoteAddr().String(), "content", string(content))
+	}
+	n, _ := conn.Write(content)
+	return n
+}
+
+// connWriter writes streamed response content to a connection, extending the
+// connection's write deadline before every write. It's used for both
+// (*Ctx).Stream and response bodies that come from an io.Reader.
+type connWriter struct {
+	app  *App
+	conn net.Conn
+	// bytesSent is incremented by the number of bytes written
+	bytesSent *int64
+	// cancel, if set, is called when a write fails, since that means the
+	// client can no longer receive the response
+	cancel context.CancelFunc
+}
+
+func (w *connWriter) Write(p []byte) (int, error) {
+	if w.app.writeTimeout != 0 {
+		_ = w.conn.SetWriteDeadline(time.Now().Add(w.app.writeTimeout))
+	}
+	if w.app.debug {
+		w.app.logDebug("streaming response content", "remote_addr", w.conn.RemoteAddr().String(), "content", string(p))
+	}
+	n, err := w.conn.Write(p)
+	*w.bytesSent += int64(n)
+	if err != nil && w.cancel != nil {
+		w.cancel()
 	}
-	_, _ = tls.Write(content)
+	return n, err
 }
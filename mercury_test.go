@@ -0,0 +1,537 @@
+package mercury
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func generateTestCertificatePEM(t *testing.T, hostnames ...string) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	if len(hostnames) == 0 {
+		hostnames = []string{"localhost"}
+	}
+
+	certPEM, keyPEM, err := GenerateSelfSignedCertificate(hostnames, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certPEM, keyPEM
+}
+
+func generateTestCertificate(t *testing.T, hostnames ...string) tls.Certificate {
+	t.Helper()
+	cert, err := tls.X509KeyPair(generateTestCertificatePEM(t, hostnames...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// startTestListener calls Listen on the app using a random port on the
+// loopback interface, returning the address that's being listened on.
+func startTestListener(t *testing.T, app *App) string {
+	t.Helper()
+
+	go func() {
+		if err := app.Listen("127.0.0.1:0"); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	for {
+		app.mu.Lock()
+		listener := app.listener
+		app.mu.Unlock()
+		if listener != nil {
+			return listener.Addr().String()
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// doTestRequest sends a request to the app over an in-memory TLS connection,
+// calling write to send the request, and returns the raw response.
+func doTestRequest(t *testing.T, app *App, write func(conn net.Conn) error) string {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+	server := tls.Server(serverConn, &tls.Config{
+		Certificates: []tls.Certificate{generateTestCertificate(t)},
+	})
+	client := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true})
+	defer client.Close()
+
+	done := make(chan struct{})
+	go func() {
+		app.processConn(context.Background(), server)
+		close(done)
+	}()
+
+	if err := write(client); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := io.ReadAll(client)
+	if err != nil && !strings.Contains(err.Error(), "closed") {
+		t.Fatal(err)
+	}
+	<-done
+
+	return string(resp)
+}
+
+func newTestApp(t *testing.T, conf ...AppConfigFunction) *App {
+	t.Helper()
+	app, err := New(append([]AppConfigFunction{WithLogger(nil)}, conf...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+func TestCtx_Stream(t *testing.T) {
+	app := newTestApp(t)
+	app.Add("/stream", func(ctx *Ctx) error {
+		if err := ctx.SetMeta("text/gemini"); err != nil {
+			return err
+		}
+		w, err := ctx.Stream()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 3; i += 1 {
+			if _, err := io.WriteString(w, "line\n"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	app.Add("/streamThenFail", func(ctx *Ctx) error {
+		w, err := ctx.Stream()
+		if err != nil {
+			return err
+		}
+		_, _ = io.WriteString(w, "partial")
+		return NewError("oh no", StatusTemporaryFailure)
+	})
+	app.Add("/streamNotSuccess", func(ctx *Ctx) error {
+		ctx.SetStatus(StatusNotFound)
+		_, err := ctx.Stream()
+		return err
+	})
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/stream", "20 text/gemini\r\nline\nline\nline\n"},
+		{"/streamThenFail", "20 text/plain\r\npartial"},
+		{"/streamNotSuccess", "40 Internal server error\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got := doTestRequest(t, app, func(conn net.Conn) error {
+				_, err := io.WriteString(conn, "gemini://localhost"+tt.path+"\r\n")
+				return err
+			})
+			if got != tt.want {
+				t.Errorf("response = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCtx_SetBodyFromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"hello.gmi": &fstest.MapFile{Data: []byte("# Hello\n")},
+		"dir":       &fstest.MapFile{Mode: fs.ModeDir},
+	}
+
+	app := newTestApp(t)
+	app.Add("/reader", func(ctx *Ctx) error {
+		ctx.SetBodyFromReader(strings.NewReader("from a reader"))
+		return nil
+	})
+	app.Add("/*name", func(ctx *Ctx) error {
+		return ctx.SetBodyFromFS(fsys, ctx.GetURLParam("name"))
+	})
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/hello.gmi", "20 text/gemini\r\n# Hello\n"},
+		{"/dir", "40 Internal server error\r\n"},
+		{"/missing.gmi", "40 Internal server error\r\n"},
+		{"/reader", "20 text/plain\r\nfrom a reader"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got := doTestRequest(t, app, func(conn net.Conn) error {
+				_, err := io.WriteString(conn, "gemini://localhost"+tt.path+"\r\n")
+				return err
+			})
+			if got != tt.want {
+				t.Errorf("response = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApp_processConn_partialReads(t *testing.T) {
+	app := newTestApp(t)
+	app.Add("/hello", func(ctx *Ctx) error {
+		ctx.SetBody("Hello world!")
+		return nil
+	})
+
+	const request = "gemini://localhost/hello\r\n"
+
+	tests := []struct {
+		name  string
+		write func(conn net.Conn) error
+		want  string
+	}{
+		{"singleWrite", func(conn net.Conn) error {
+			_, err := io.WriteString(conn, request)
+			return err
+		}, "20 text/plain\r\nHello world!"},
+		{"byteByByte", func(conn net.Conn) error {
+			for i := 0; i < len(request); i += 1 {
+				if _, err := conn.Write([]byte{request[i]}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, "20 text/plain\r\nHello world!"},
+		{"splitBetweenCRAndLF", func(conn net.Conn) error {
+			if _, err := io.WriteString(conn, request[:len(request)-1]); err != nil {
+				return err
+			}
+			_, err := io.WriteString(conn, request[len(request)-1:])
+			return err
+		}, "20 text/plain\r\nHello world!"},
+		{"closedBeforeCRLF", func(conn net.Conn) error {
+			if _, err := io.WriteString(conn, "gemini://localhost/hello"); err != nil {
+				return err
+			}
+			return conn.(*tls.Conn).CloseWrite()
+		}, "59 Malformed request\r\n"},
+		{"tooLong", func(conn net.Conn) error {
+			for i := 0; i < maxRequestLength; i += 1 {
+				if _, err := conn.Write([]byte{'a'}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, "59 Request URL length is greater than 1024 characters\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := doTestRequest(t, app, tt.write); got != tt.want {
+				t.Errorf("response = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApp_ShutdownWithContext(t *testing.T) {
+	tests := []struct {
+		name        string
+		handlerTime time.Duration
+		timeout     time.Duration
+		want        int
+		wantErr     error
+		wantResp    string
+	}{
+		{"drained", 50 * time.Millisecond, time.Second, 0, nil, "20 text/plain\r\ndone"},
+		{"forceClosed", time.Minute, 50 * time.Millisecond, 1, context.DeadlineExceeded, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{})
+			release := make(chan struct{})
+			defer close(release)
+
+			app := newTestApp(t, WithX509KeyData(generateTestCertificatePEM(t)), WithDisableStartupMessage())
+			app.Add("/", func(ctx *Ctx) error {
+				close(started)
+				select {
+				case <-time.After(tt.handlerTime):
+				case <-release:
+				}
+				ctx.SetBody("done")
+				return nil
+			})
+			addr := startTestListener(t, app)
+
+			conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if _, err := io.WriteString(conn, "gemini://localhost/\r\n"); err != nil {
+				t.Fatal(err)
+			}
+			<-started
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			got, err := app.ShutdownWithContext(ctx)
+			if err != tt.wantErr {
+				t.Errorf("ShutdownWithContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ShutdownWithContext() = %v, want %v", got, tt.want)
+			}
+
+			resp, _ := io.ReadAll(conn)
+			if string(resp) != tt.wantResp {
+				t.Errorf("response = %q, want %q", resp, tt.wantResp)
+			}
+		})
+	}
+}
+
+func TestCtx_Context(t *testing.T) {
+	t.Run("handlerTimeout", func(t *testing.T) {
+		app := newTestApp(t, WithHandlerTimeout(10*time.Millisecond))
+		app.Add("/", func(ctx *Ctx) error {
+			<-ctx.Context().Done()
+			return NewError(ctx.Context().Err().Error(), StatusTemporaryFailure)
+		})
+
+		got := doTestRequest(t, app, func(conn net.Conn) error {
+			_, err := io.WriteString(conn, "gemini://localhost/\r\n")
+			return err
+		})
+		if want := "40 context deadline exceeded\r\n"; got != want {
+			t.Errorf("response = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("clientDisconnect", func(t *testing.T) {
+		cancelled := make(chan error, 1)
+		app := newTestApp(t)
+		app.Add("/", func(ctx *Ctx) error {
+			w, err := ctx.Stream()
+			for err == nil {
+				time.Sleep(time.Millisecond)
+				_, err = w.Write([]byte("."))
+			}
+			cancelled <- ctx.Context().Err()
+			return nil
+		})
+
+		_ = doTestRequest(t, app, func(conn net.Conn) error {
+			if _, err := io.WriteString(conn, "gemini://localhost/\r\n"); err != nil {
+				return err
+			}
+			return conn.Close()
+		})
+
+		select {
+		case err := <-cancelled:
+			if err != context.Canceled {
+				t.Errorf("context error = %v, want %v", err, context.Canceled)
+			}
+		case <-time.After(time.Second):
+			t.Error("handler did not notice that the client disconnected")
+		}
+	})
+
+	t.Run("clientHalfClose", func(t *testing.T) {
+		app := newTestApp(t)
+		app.Add("/", func(ctx *Ctx) error {
+			time.Sleep(20 * time.Millisecond)
+			if err := ctx.Context().Err(); err != nil {
+				return err
+			}
+			ctx.SetBody("Hello world!")
+			return nil
+		})
+
+		got := doTestRequest(t, app, func(conn net.Conn) error {
+			if _, err := io.WriteString(conn, "gemini://localhost/\r\n"); err != nil {
+				return err
+			}
+			return conn.(*tls.Conn).CloseWrite()
+		})
+		if want := "20 text/plain\r\nHello world!"; got != want {
+			t.Errorf("response = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("writeTimeout", func(t *testing.T) {
+		app := newTestApp(t, WithWriteTimeout(20*time.Millisecond))
+		app.Add("/", func(ctx *Ctx) error {
+			select {
+			case <-ctx.Context().Done():
+				ctx.SetBody(context.Cause(ctx.Context()).Error())
+			case <-time.After(time.Second):
+				ctx.SetBody("not cancelled")
+			}
+			return nil
+		})
+
+		got := doTestRequest(t, app, func(conn net.Conn) error {
+			_, err := io.WriteString(conn, "gemini://localhost/\r\n")
+			return err
+		})
+		if want := "20 text/plain\r\ncontext deadline exceeded"; got != want {
+			t.Errorf("response = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("writeTimeoutWhileStreaming", func(t *testing.T) {
+		app := newTestApp(t, WithWriteTimeout(100*time.Millisecond))
+		app.Add("/", func(ctx *Ctx) error {
+			w, err := ctx.Stream()
+			if err != nil {
+				return err
+			}
+			for i := 0; i < 5; i++ {
+				time.Sleep(50 * time.Millisecond)
+				if err := ctx.Context().Err(); err != nil {
+					_, _ = w.Write([]byte(err.Error()))
+					return nil
+				}
+				if _, err := w.Write([]byte(".")); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		got := doTestRequest(t, app, func(conn net.Conn) error {
+			_, err := io.WriteString(conn, "gemini://localhost/\r\n")
+			return err
+		})
+		if want := "20 text/plain\r\n....."; got != want {
+			t.Errorf("response = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestApp_panicRecovery(t *testing.T) {
+	var handled error
+	app := newTestApp(t, WithErrorHandler(func(ctx *Ctx, err error) error {
+		handled = err
+		return DefaultErrorHandler(ctx, err)
+	}))
+	app.Add("/", func(ctx *Ctx) error {
+		panic("oh no")
+	})
+
+	got := doTestRequest(t, app, func(conn net.Conn) error {
+		_, err := io.WriteString(conn, "gemini://localhost/\r\n")
+		return err
+	})
+	if want := "40 Internal server error\r\n"; got != want {
+		t.Errorf("response = %q, want %q", got, want)
+	}
+
+	var pe *PanicError
+	if !errors.As(handled, &pe) {
+		t.Fatalf("error handler got %v, want *PanicError", handled)
+	}
+	if pe.Value != "oh no" || len(pe.Stack) == 0 {
+		t.Errorf("PanicError = %#v, want value \"oh no\" and a stack trace", pe)
+	}
+}
+
+func TestApp_Serve(t *testing.T) {
+	handler := func(ctx *Ctx) error {
+		ctx.SetBody(fmt.Sprintf("%d certificates", len(ctx.GetClientCertificates())))
+		return nil
+	}
+
+	dial := map[string]func(t *testing.T, network, addr string) net.Conn{
+		"tls": func(t *testing.T, network, addr string) net.Conn {
+			clientCert := generateTestCertificate(t, "client")
+			conn, err := tls.Dial(network, addr, &tls.Config{
+				InsecureSkipVerify: true,
+				Certificates:       []tls.Certificate{clientCert},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return conn
+		},
+		"plain": func(t *testing.T, network, addr string) net.Conn {
+			conn, err := net.Dial(network, addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return conn
+		},
+	}
+
+	tests := []struct {
+		name    string
+		network string
+		serve   func(app *App, l net.Listener) error
+		dial    string
+		want    string
+	}{
+		{"Serve", "tcp", func(app *App, l net.Listener) error { return app.Serve(l) }, "tls", "20 text/plain\r\n1 certificates"},
+		{"ServeTLS default config", "tcp", func(app *App, l net.Listener) error { return app.ServeTLS(l, nil) }, "tls", "20 text/plain\r\n1 certificates"},
+		{"ServeTLS custom config", "tcp", func(app *App, l net.Listener) error {
+			return app.ServeTLS(l, &tls.Config{MinVersion: tls.VersionTLS13})
+		}, "tls", "20 text/plain\r\n0 certificates"},
+		{"ServeTLS modified app config", "tcp", func(app *App, l net.Listener) error {
+			config := app.TLSConfig()
+			config.MinVersion = tls.VersionTLS13
+			return app.ServeTLS(l, config)
+		}, "tls", "20 text/plain\r\n1 certificates"},
+		{"ServeWithoutTLS on Unix socket", "unix", func(app *App, l net.Listener) error { return app.ServeWithoutTLS(l) }, "plain", "20 text/plain\r\n0 certificates"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t, WithX509KeyData(generateTestCertificatePEM(t)))
+			app.Add("/", handler)
+
+			addr := "127.0.0.1:0"
+			if tt.network == "unix" {
+				addr = filepath.Join(t.TempDir(), "mercury.sock")
+			}
+			l, err := net.Listen(tt.network, addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			served := make(chan error, 1)
+			go func() { served <- tt.serve(app, l) }()
+
+			conn := dial[tt.dial](t, tt.network, l.Addr().String())
+			if _, err := conn.Write([]byte("gemini://localhost/\r\n")); err != nil {
+				t.Fatal(err)
+			}
+			resp, _ := io.ReadAll(conn)
+			_ = conn.Close()
+
+			if string(resp) != tt.want {
+				t.Errorf("response = %#v, want %#v", string(resp), tt.want)
+			}
+
+			if err := app.Shutdown(); err != nil {
+				t.Fatal(err)
+			}
+			if err := <-served; err != nil {
+				t.Errorf("serve returned %v after shutdown", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+// Package mercurytest provides utilities for testing Mercury applications
+// without a network connection.
+//
+// A Server serves an app on an in-memory listener, so requests made with it
+// go through the app's handlers, middleware and error handler exactly as they
+// would for a real client.
+//
+//	server := mercurytest.NewServer(app)
+//	defer server.Close()
+//
+//	resp, err := server.Get("gemini://localhost/hello")
+package mercurytest
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/codemicro/mercury"
+)
+
+// Server serves a Mercury app on an in-memory listener.
+type Server struct {
+	app      *mercury.App
+	listener *pipeListener
+	served   chan error
+}
+
+// NewServer starts serving the provided app in memory. The app doesn't need
+// to have any certificates configured, since the server presents its own.
+//
+// The server must be closed with Close once it is no longer needed.
+func NewServer(app *mercury.App) *Server {
+	certificate, err := NewCertificate("localhost")
+	if err != nil {
+		// generating a certificate only fails if the system's source of
+		// randomness does
+		panic(fmt.Errorf("mercurytest: could not generate server certificate: %w", err))
+	}
+
+	s := &Server{
+		app:      app,
+		listener: newPipeListener(),
+		served:   make(chan error, 1),
+	}
+
+	go func() {
+		s.served <- app.ServeTLS(s.listener, &tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			ClientAuth:   tls.RequestClientCert,
+			MinVersion:   tls.VersionTLS12,
+		})
+	}()
+
+	return s
+}
+
+// Close shuts down the app and waits for it to stop serving.
+func (s *Server) Close() error {
+	if err := s.app.Shutdown(); err != nil {
+		return err
+	}
+	// the app may not have started serving yet, in which case Shutdown
+	// doesn't know about the listener
+	_ = s.listener.Close()
+
+	if err := <-s.served; err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// Request is a request that can be sent to a Server.
+type Request struct {
+	// URL is sent to the app as-is, followed by a CRLF, so it can be used to
+	// send malformed requests.
+	URL string
+	// Certificate is presented to the app as a client certificate if it is
+	// not nil.
+	Certificate *tls.Certificate
+}
+
+// Get sends a request for the provided URL without a client certificate.
+func (s *Server) Get(rawURL string) (*mercury.Response, error) {
+	return s.Do(&Request{URL: rawURL})
+}
+
+// Do sends a request to the app and reads the whole response.
+func (s *Server) Do(req *Request) (*mercury.Response, error) {
+	conn, err := s.listener.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{InsecureSkipVerify: true}
+	if req.Certificate != nil {
+		config.Certificates = []tls.Certificate{*req.Certificate}
+	}
+	client := tls.Client(conn, config)
+	defer client.Close()
+
+	if _, err := client.Write([]byte(req.URL + "\r\n")); err != nil {
+		return nil, err
+	}
+
+	return mercury.ReadResponse(client)
+}
+
+// NewCertificate generates a self-signed certificate with the provided common
+// name, which can be used as a client certificate in a Request.
+func NewCertificate(commonName string) (tls.Certificate, error) {
+	certPEM, keyPEM, err := mercury.GenerateSelfSignedCertificate([]string{commonName}, time.Hour)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// pipeListener is a net.Listener that hands out connections created with
+// net.Pipe.
+type pipeListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+// dial creates a new connection to the listener, returning the client's end.
+func (l *pipeListener) dial() (net.Conn, error) {
+	server, client := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		return nil, errors.New("mercurytest: server is closed")
+	}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
@@ -0,0 +1,85 @@
+package mercurytest
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/codemicro/mercury"
+)
+
+func TestServer_Do(t *testing.T) {
+	app, err := mercury.New(mercury.WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Use(func(ctx *mercury.Ctx) error {
+		ctx.SetLocal("greeting", "Hello")
+		return ctx.Next()
+	})
+	app.Add("/hello/:name", func(ctx *mercury.Ctx) error {
+		greeting, _ := mercury.Local[string](ctx, "greeting")
+		ctx.SetBody(greeting + " " + ctx.GetURLParam("name"))
+		return nil
+	})
+	app.Add("/whoami", func(ctx *mercury.Ctx) error {
+		certs := ctx.GetClientCertificates()
+		if len(certs) == 0 {
+			ctx.SetStatus(mercury.StatusClientCertificateRequired)
+			ctx.SetMeta("Certificate required")
+			return nil
+		}
+		ctx.SetBody(certs[0].Subject.CommonName)
+		return nil
+	})
+
+	server := NewServer(app)
+	defer server.Close()
+
+	clientCert, err := NewCertificate("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		url         string
+		certificate *tls.Certificate
+		wantStatus  mercury.Status
+		wantMeta    string
+		wantBody    string
+	}{
+		{"handler", "gemini://localhost/hello/world", nil, mercury.StatusSuccess, "text/plain", "Hello world"},
+		{"not found", "gemini://localhost/nothing", nil, mercury.StatusNotFound, "Not found", ""},
+		{"malformed request", "localhost/hello/world", nil, mercury.StatusBadRequest, "Request URL has no scheme", ""},
+		{"without certificate", "gemini://localhost/whoami", nil, mercury.StatusClientCertificateRequired, "Certificate required", ""},
+		{"with certificate", "gemini://localhost/whoami", &clientCert, mercury.StatusSuccess, "text/plain", "alice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := server.Do(&Request{URL: tt.url, Certificate: tt.certificate})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Status() != tt.wantStatus || resp.Meta() != tt.wantMeta || string(resp.Body()) != tt.wantBody {
+				t.Errorf("response = %d %#v %#v, want %d %#v %#v", resp.Status(), resp.Meta(), string(resp.Body()), tt.wantStatus, tt.wantMeta, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestServer_Close(t *testing.T) {
+	app, err := mercury.New(mercury.WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer(app)
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := server.Get("gemini://localhost/"); err == nil {
+		t.Error("expected an error when making a request to a closed server")
+	}
+}
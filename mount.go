@@ -0,0 +1,66 @@
+package mercury
+
+import "strings"
+
+// Mount serves every request under the provided path prefix using a separate
+// app. The handlers, middleware and error handler of the sub-app are used for
+// these requests, and the request URL seen by them (including any URL
+// parameters) is relative to the prefix.
+//
+// Only the routing and error handling of the sub-app are used. Everything else,
+// including certificates and timeouts, comes from the app that's listening.
+//
+// If the sub-app has no handlers other than middleware that match a request,
+// the request carries on down the parent's callstack without running any of
+// the sub-app's middleware.
+func (app *App) Mount(prefix string, subApp *App) {
+	mountOn(app.router, joinPath("/", prefix), subApp)
+}
+
+// Mount serves every request under the provided path prefix, relative to the
+// group's prefix, using a separate app. See (*App).Mount.
+func (g *Group) Mount(prefix string, subApp *App) {
+	mountOn(g.router, joinPath(g.prefix, prefix), subApp)
+}
+
+func mountOn(r *router, prefix string, subApp *App) {
+	r.add(prefix, subApp.mountedHandler(len(splitPath(prefix))), true)
+}
+
+// mountedHandler returns a middleware function that runs the app's callstack
+// and error handler for requests whose paths start with a prefix made up of
+// prefixLength components.
+func (app *App) mountedHandler(prefixLength int) HandlerFunction {
+	return func(ctx *Ctx) error {
+		req := ctx.request.relativeTo(prefixLength)
+		callstack := app.match(req)
+		if !hasEndpoint(callstack) {
+			// middleware alone can't serve the request, so it's left to the
+			// parent rather than ending in the sub-app's Not found
+			return ctx.Next()
+		}
+
+		parentRequest, parentCallstack, parentStackPointer, parentMountPrefix := ctx.request, ctx.callstack, ctx.stackPointer, ctx.mountPrefix
+		ctx.request, ctx.callstack, ctx.stackPointer = req, callstack, 0
+		ctx.mountPrefix += strings.Join(splitPath(parentRequest.URL.Path)[:prefixLength], "/")
+		defer func() {
+			ctx.request, ctx.callstack, ctx.stackPointer, ctx.mountPrefix = parentRequest, parentCallstack, parentStackPointer, parentMountPrefix
+		}()
+
+		if err := app.runCallstack(ctx); err != nil {
+			return app.errorHandler(ctx, err)
+		}
+		return nil
+	}
+}
+
+// hasEndpoint reports whether a callstack contains any handlers that aren't
+// middleware.
+func hasEndpoint(callstack []*handler) bool {
+	for _, h := range callstack {
+		if !h.isMiddleware {
+			return true
+		}
+	}
+	return false
+}
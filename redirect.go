@@ -0,0 +1,116 @@
+package mercury
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var errorRedirectLoop = errors.New("mercury: redirect target is the same as the request URL")
+
+// Redirect sets the response to temporarily redirect the client to the target
+// URL. Relative targets are resolved against the request URL in the same way a
+// client would resolve them.
+//
+// An error is returned if the resolved URL is longer than 1024 bytes or would
+// redirect the client to the URL they requested.
+func (ctx *Ctx) Redirect(target string) error {
+	return ctx.redirect(StatusTemporaryRedirect, target)
+}
+
+// PermanentRedirect behaves identically to Redirect, except it tells the client
+// that the requested resource has permanently moved to the target URL.
+func (ctx *Ctx) PermanentRedirect(target string) error {
+	return ctx.redirect(StatusPermanentRedirect, target)
+}
+
+func (ctx *Ctx) redirect(status Status, target string) error {
+	ref, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("mercury: invalid redirect target: %w", err)
+	}
+
+	// Resolve against the URL the client actually requested, which differs
+	// from GetRequestURL when running inside a mounted app.
+	resolved := ctx.originalURL.ResolveReference(ref).String()
+	if len(resolved) > 1024 {
+		return fmt.Errorf("mercury: redirect target too long (len %d > 1024)", len(resolved))
+	}
+	if resolved == ctx.originalURL.String() {
+		return errorRedirectLoop
+	}
+
+	if err := ctx.SetMeta(resolved); err != nil {
+		return err
+	}
+	ctx.SetStatus(status)
+	ctx.ClearBody()
+	return nil
+}
+
+// Redirect registers a permanent redirect from a path to a target URL, for use
+// when content has moved. Relative targets are resolved against the path being
+// redirected from.
+//
+// Redirect panics if the redirect would create a loop with other redirects
+// registered on the app.
+func (app *App) Redirect(from, to string) {
+	app.router.addRedirect(joinPath("/", from), to)
+}
+
+// Redirect registers a permanent redirect from a path, relative to the group's
+// prefix, to a target URL. See (*App).Redirect.
+func (g *Group) Redirect(from, to string) {
+	g.router.addRedirect(joinPath(g.prefix, from), to)
+}
+
+// addRedirect registers a handler that redirects from one path to another and
+// records it in the router's redirect table, panicking if doing so would create
+// a redirect loop.
+func (r *router) addRedirect(from, to string) {
+	ref, err := url.Parse(to)
+	if err != nil {
+		panic(fmt.Errorf("mercury: invalid redirect target %#v: %w", to, err))
+	}
+
+	originalFrom := from
+	from = strings.ToLower(from)
+
+	// Redirects to other hosts can't form loops with redirects on this router,
+	// so only paths on this host are tracked.
+	var target string
+	if ref.Scheme == "" && ref.Host == "" {
+		target = strings.ToLower((&url.URL{Path: from}).ResolveReference(ref).Path)
+	}
+
+	seen := map[string]bool{from: true}
+	for next := target; next != ""; next = r.redirects[next] {
+		if seen[next] {
+			panic(fmt.Errorf("mercury: redirect from %#v to %#v creates a redirect loop", from, to))
+		}
+		seen[next] = true
+	}
+
+	if r.redirects == nil {
+		r.redirects = make(map[string]string)
+	}
+	r.redirects[from] = target
+
+	if target == "" {
+		r.add(from, func(ctx *Ctx) error {
+			return ctx.PermanentRedirect(to)
+		}, false)
+		return
+	}
+
+	// Targets on this host are resolved in the same path space that the
+	// redirect was registered in, which only differs from the one the client
+	// sees when running inside a mounted app.
+	resolved := (&url.URL{Path: originalFrom}).ResolveReference(ref)
+	r.add(from, func(ctx *Ctx) error {
+		u := *resolved
+		u.Path, u.RawPath = strings.TrimSuffix(ctx.mountPrefix, "/")+u.Path, ""
+		return ctx.PermanentRedirect(u.String())
+	}, false)
+}
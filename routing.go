@@ -1,28 +1,191 @@
 package mercury
 
 import (
+	"fmt"
+	"sort"
 	"strings"
 )
 
-func doesHandlerMatchPath(path []string, h *handler) bool {
-	if h.isMiddleware {
-		if len(h.pathComponents) > len(path) {
-			return false
+// router is a trie of path components that handlers are stored in. Finding
+// every handler that applies to a request path costs time proportional to the
+// length of that path, not to the number of handlers registered.
+type router struct {
+	root *routeNode
+	// numHandlers is shared between routers whose handlers are matched
+	// together, so that handlers can be ordered across all of them
+	numHandlers *int
+
+	// redirects maps paths registered with addRedirect to the path they
+	// redirect to, or an empty string if they redirect to another host.
+	redirects map[string]string
+}
+
+type routeNode struct {
+	static   map[string]*routeNode
+	param    *routeNode
+	wildcard *routeNode
+
+	// middleware applies to any path that passes through this node, whereas
+	// endpoints only apply to paths that end at it.
+	middleware []*handler
+	endpoints  []*handler
+}
+
+func newRouter() *router {
+	return &router{root: new(routeNode), numHandlers: new(int)}
+}
+
+// newSibling creates an empty router whose handlers can be matched alongside
+// those of r, keeping the order they were registered in across both.
+func (r *router) newSibling() *router {
+	return &router{root: new(routeNode), numHandlers: r.numHandlers}
+}
+
+// add registers a handler function on the provided path. Handlers are returned
+// from match in the order they were added in.
+//
+// A path component starting with a colon (eg. ":name") matches any single
+// component, and can be made optional by ending it with a question mark (eg.
+// ":name?"). Only the final components of a path may be optional. A final
+// component starting with an asterisk (eg. "*path") matches the remainder of
+// the path, including when there is nothing left to match.
+//
+// add panics if the path is invalid.
+func (r *router) add(path string, hf HandlerFunction, isMiddleware bool) {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	components := splitPath(strings.ToLower(path))
+
+	variants, err := expandPathComponents(components)
+	if err != nil {
+		panic(fmt.Errorf("mercury: invalid path %#v: %w", path, err))
+	}
+
+	if isMiddleware {
+		// middleware is matched by prefix, so the shortest variant covers
+		// every other one
+		variants = variants[:1]
+	}
+
+	order := *r.numHandlers
+	*r.numHandlers += 1
+
+	for _, variant := range variants {
+		r.insert(&handler{
+			f:              hf,
+			pathComponents: variant,
+			isMiddleware:   isMiddleware,
+			order:          order,
+		})
+	}
+}
+
+// expandPathComponents validates a set of path components and returns every
+// set of path components that they represent once optional components are
+// taken into account, shortest first.
+func expandPathComponents(components []string) ([][]string, error) {
+	full := make([]string, len(components))
+	for i, component := range components {
+		full[i] = strings.TrimSuffix(component, "?")
+	}
+
+	var variants [][]string
+	for i, component := range components {
+		if strings.HasPrefix(component, "*") && i != len(components)-1 {
+			return nil, fmt.Errorf("wildcard component %#v is not the final component", component)
+		}
+
+		isOptional := strings.HasPrefix(component, ":") && strings.HasSuffix(component, "?")
+		if isOptional {
+			variants = append(variants, full[:i])
+			continue
+		}
+
+		if len(variants) != 0 {
+			return nil, fmt.Errorf("required component %#v follows an optional component", component)
 		}
-		return doPathComponentsMatch(path[:len(h.pathComponents)], h.pathComponents)
 	}
 
-	return doPathComponentsMatch(path, h.pathComponents)
+	return append(variants, full), nil
 }
 
-func doPathComponentsMatch(input, source []string) bool {
-	if len(input) != len(source) {
-		return false
+func (r *router) insert(h *handler) {
+	n := r.root
+	for _, component := range h.pathComponents {
+		n = n.child(component)
+	}
+
+	if h.isMiddleware {
+		n.middleware = append(n.middleware, h)
+	} else {
+		n.endpoints = append(n.endpoints, h)
 	}
-	for i := 0; i < len(input); i += 1 {
-		if !(input[i] == source[i] || strings.HasPrefix(source[i], ":")) {
-			return false
+}
+
+func (n *routeNode) child(component string) *routeNode {
+	if strings.HasPrefix(component, "*") {
+		if n.wildcard == nil {
+			n.wildcard = new(routeNode)
 		}
+		return n.wildcard
+	}
+
+	if strings.HasPrefix(component, ":") {
+		if n.param == nil {
+			n.param = new(routeNode)
+		}
+		return n.param
+	}
+
+	if n.static == nil {
+		n.static = make(map[string]*routeNode)
+	}
+	c, found := n.static[component]
+	if !found {
+		c = new(routeNode)
+		n.static[component] = c
+	}
+	return c
+}
+
+// match returns every handler that applies to the provided path, in the order
+// they were registered in.
+func (r *router) match(path []string) []*handler {
+	return matchRouters(path, r)
+}
+
+// matchRouters returns every handler in any of the provided routers that
+// applies to the provided path, in the order they were registered in. The
+// routers must be siblings.
+func matchRouters(path []string, routers ...*router) []*handler {
+	var handlers []*handler
+	for _, r := range routers {
+		handlers = r.root.collect(path, handlers)
+	}
+	sort.Slice(handlers, func(i, j int) bool {
+		return handlers[i].order < handlers[j].order
+	})
+	return handlers
+}
+
+func (n *routeNode) collect(path []string, dst []*handler) []*handler {
+	dst = append(dst, n.middleware...)
+
+	if n.wildcard != nil {
+		dst = append(dst, n.wildcard.middleware...)
+		dst = append(dst, n.wildcard.endpoints...)
+	}
+
+	if len(path) == 0 {
+		return append(dst, n.endpoints...)
+	}
+
+	if c, found := n.static[path[0]]; found {
+		dst = c.collect(path[1:], dst)
+	}
+	if n.param != nil {
+		dst = n.param.collect(path[1:], dst)
 	}
-	return true
+	return dst
 }
@@ -0,0 +1,329 @@
+package mercury
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func Test_router_match(t *testing.T) {
+	r := newRouter()
+	paths := []struct {
+		path         string
+		isMiddleware bool
+	}{
+		{"/", true},              // 0
+		{"/hello", false},        // 1
+		{"/hello/:name", false},  // 2
+		{"/hello/abi", false},    // 3
+		{"/hello", true},         // 4
+		{"/", false},             // 5
+		{"/files/*path", false},  // 6
+		{"/wiki/:a?/:b?", false}, // 7
+		{"/wiki/:page?", true},   // 8
+		{"/Mixed/Case", false},   // 9
+	}
+	for _, p := range paths {
+		r.add(p.path, nil, p.isMiddleware)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want []int
+	}{
+		{"root", "/", []int{0, 5}},
+		{"static", "/hello", []int{0, 1, 4}},
+		{"paramAndStaticInRegistrationOrder", "/hello/abi", []int{0, 2, 3, 4}},
+		{"param", "/hello/bob", []int{0, 2, 4}},
+		{"noEndpoint", "/hello/bob/smith", []int{0, 4}},
+		{"middlewareOnly", "/nothing", []int{0}},
+		{"wildcardEmpty", "/files", []int{0, 6}},
+		{"wildcardSingle", "/files/a", []int{0, 6}},
+		{"wildcardMany", "/files/a/b/c", []int{0, 6}},
+		{"optionalNone", "/wiki", []int{0, 7, 8}},
+		{"optionalOne", "/wiki/a", []int{0, 7, 8}},
+		{"optionalBoth", "/wiki/a/b", []int{0, 7, 8}},
+		{"optionalTooMany", "/wiki/a/b/c", []int{0, 8}},
+		{"lowercased", "/mixed/case", []int{0, 9}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			for _, h := range r.match(splitPath(tt.path)) {
+				got = append(got, h.order)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_expandPathComponents(t *testing.T) {
+	tests := []struct {
+		name    string
+		arg     string
+		want    [][]string
+		wantErr bool
+	}{
+		{"normal", "/hello/:name", [][]string{{"", "hello", ":name"}}, false},
+		{"optional", "/a/:b?/:c?", [][]string{{"", "a"}, {"", "a", ":b"}, {"", "a", ":b", ":c"}}, false},
+		{"wildcard", "/a/*b", [][]string{{"", "a", "*b"}}, false},
+		{"requiredAfterOptional", "/a/:b?/c", nil, true},
+		{"wildcardAfterOptional", "/a/:b?/*c", nil, true},
+		{"wildcardNotLast", "/a/*b/c", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandPathComponents(splitPath(tt.arg))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expandPathComponents() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expandPathComponents() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCtx_GetURLParam(t *testing.T) {
+	tests := []struct {
+		name  string
+		route string
+		path  string
+		param string
+		want  string
+	}{
+		{"param", "/hello/:name", "/hello/Abi", "name", "Abi"},
+		{"paramCaseInsensitive", "/hello/:name", "/hello/Abi", "NAME", "Abi"},
+		{"wildcard", "/files/*path", "/files/Docs/index.gmi", "path", "Docs/index.gmi"},
+		{"wildcardEmpty", "/files/*path", "/files", "path", ""},
+		{"optionalMissing", "/wiki/:page?", "/wiki", "page", ""},
+		{"optionalPresent", "/wiki/:page?", "/wiki/Home", "page", "Home"},
+		{"unknown", "/hello/:name", "/hello/Abi", "nope", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRouter()
+			r.add(tt.route, nil, false)
+
+			req, err := parseRequest([]byte("gemini://example.com" + tt.path + "\r\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := newCtx(nil, nil, r.match(req.pathComponents), req)
+			ctx.stackPointer = 1
+
+			if got := ctx.GetURLParam(tt.param); got != tt.want {
+				t.Errorf("GetURLParam() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Benchmark_router_match(b *testing.B) {
+	for _, numRoutes := range []int{10, 100, 1000, 10000} {
+		r := newRouter()
+		r.add("/", nil, true)
+		for i := 0; i < numRoutes; i += 1 {
+			r.add("/section"+strconv.Itoa(i)+"/page/:id", nil, false)
+		}
+		path := splitPath(fmt.Sprintf("/section%d/page/123", numRoutes/2))
+
+		b.Run(strconv.Itoa(numRoutes), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i += 1 {
+				if len(r.match(path)) != 2 {
+					b.Fatal("unexpected number of handlers matched")
+				}
+			}
+		})
+	}
+}
+
+func TestGroup(t *testing.T) {
+	app, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var called []string
+	record := func(name string) HandlerFunction {
+		return func(ctx *Ctx) error {
+			called = append(called, name)
+			return ctx.Next()
+		}
+	}
+
+	app.Use(record("global"))
+	admin := app.Group("/admin", record("adminAuth"))
+	admin.Add("/users", record("users"))
+	nested := admin.Group("settings", record("settingsMiddleware"))
+	nested.Add("/", record("settings"))
+	app.Add("/users", record("publicUsers"))
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"/admin/users", []string{"global", "adminAuth", "users"}},
+		{"/admin/settings", []string{"global", "adminAuth", "settingsMiddleware", "settings"}},
+		{"/users", []string{"global", "publicUsers"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			called = nil
+			ctx := newCtx(nil, nil, app.router.match(splitPath(tt.path)), nil)
+			_ = ctx.Next()
+			if !reflect.DeepEqual(called, tt.want) {
+				t.Errorf("called = %v, want %v", called, tt.want)
+			}
+		})
+	}
+}
+
+func TestApp_Mount(t *testing.T) {
+	sub, err := New(WithErrorHandler(func(ctx *Ctx, err error) error {
+		ctx.SetBody("sub error handler: " + err.Error())
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.Add("/", func(ctx *Ctx) error {
+		ctx.SetBody("index " + ctx.GetRequestURL().Path)
+		return nil
+	})
+	sub.Add("/entry/:id", func(ctx *Ctx) error {
+		ctx.SetBody("entry " + ctx.GetURLParam("id") + " " + ctx.GetRequestURL().Path)
+		return nil
+	})
+	sub.Add("/fail", func(ctx *Ctx) error {
+		return NewError("failed", StatusTemporaryFailure)
+	})
+	sub.Use(func(ctx *Ctx) error {
+		ctx.SetLocal("sub middleware", true)
+		return ctx.Next()
+	})
+
+	app, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Mount("/guestbook", sub)
+	app.Add("/guestbook/other", func(ctx *Ctx) error {
+		if ctx.Locals("sub middleware") != nil {
+			return errors.New("sub-app middleware ran for a parent handler")
+		}
+		ctx.SetBody("parent " + ctx.GetRequestURL().Path)
+		return nil
+	})
+	app.Add("/guestbook/other/:name", func(ctx *Ctx) error {
+		ctx.SetBody("parent " + ctx.GetURLParam("name"))
+		return nil
+	})
+
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"/guestbook", "index /", false},
+		{"/guestbook/entry/Abc", "entry Abc /entry/Abc", false},
+		{"/guestbook/fail", "sub error handler: failed", false},
+		{"/guestbook/other", "parent /guestbook/other", false},
+		{"/guestbook/other/Abc", "parent Abc", false},
+		{"/elsewhere", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req, err := parseRequest([]byte("gemini://example.com" + tt.path + "\r\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := newCtx(nil, nil, app.router.match(req.pathComponents), req)
+			if err := ctx.Next(); (err != nil) != tt.wantErr {
+				t.Errorf("Next() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := string(ctx.response.content); got != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+			if ctx.GetRequestURL().Path != tt.path {
+				t.Errorf("request URL was not restored after mounted app returned")
+			}
+		})
+	}
+}
+
+func TestApp_Redirect_loops(t *testing.T) {
+	tests := []struct {
+		name      string
+		redirects [][2]string
+		wantPanic bool
+	}{
+		{"chain", [][2]string{{"/a", "/b"}, {"/b", "/c"}}, false},
+		{"self", [][2]string{{"/a", "/a"}}, true},
+		{"selfRelative", [][2]string{{"/dir/a", "a"}}, true},
+		{"selfDifferentCase", [][2]string{{"/a", "/A"}}, true},
+		{"cycle", [][2]string{{"/a", "/b"}, {"/b", "/c"}, {"/c", "/a"}}, true},
+		{"otherHost", [][2]string{{"/a", "gemini://example.org/b"}, {"/b", "/a"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); (r != nil) != tt.wantPanic {
+					t.Errorf("Redirect() panic = %v, wantPanic %v", r, tt.wantPanic)
+				}
+			}()
+			app := newTestApp(t)
+			for _, r := range tt.redirects {
+				app.Redirect(r[0], r[1])
+			}
+		})
+	}
+}
+
+func TestApp_Host(t *testing.T) {
+	app := newTestApp(t)
+
+	var called []string
+	record := func(name string) HandlerFunction {
+		return func(ctx *Ctx) error {
+			called = append(called, name)
+			return ctx.Next()
+		}
+	}
+
+	app.Use(record("global"))
+	one := app.Host("one.example")
+	one.Use(record("oneMiddleware"))
+	one.Add("/", record("oneIndex"))
+	app.Add("/", record("sharedIndex"))
+	app.Host("TWO.example").Add("/", record("twoIndex"))
+
+	tests := []struct {
+		url  string
+		want []string
+	}{
+		{"gemini://one.example/", []string{"global", "oneMiddleware", "oneIndex", "sharedIndex"}},
+		{"gemini://two.example:1965/", []string{"global", "sharedIndex", "twoIndex"}},
+		{"gemini://three.example/", []string{"global", "sharedIndex"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			req, err := parseRequest([]byte(tt.url + "\r\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			called = nil
+			_ = newCtx(app, nil, app.match(req), req).Next()
+			if !reflect.DeepEqual(called, tt.want) {
+				t.Errorf("called = %v, want %v", called, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package mercury
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SelfSignedCertificateValidity is how long certificates generated by
+// WithSelfSignedCertificate are valid for. Gemini clients pin certificates on
+// first use, so certificates should be long-lived.
+const SelfSignedCertificateValidity = 100 * 365 * 24 * time.Hour
+
+// GenerateSelfSignedCertificate generates a self-signed ECDSA P-256
+// certificate for the provided hostnames, which can include IP addresses,
+// returning the certificate and its private key PEM-encoded. The first
+// hostname is used as the certificate's common name.
+func GenerateSelfSignedCertificate(hostnames []string, validity time.Duration) (certPEM, keyPEM []byte, err error) {
+	if len(hostnames) == 0 {
+		return nil, nil, errors.New("mercury: at least one hostname is required")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: hostnames[0]},
+		NotBefore:             now,
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, hostname := range hostnames {
+		if ip := net.ParseIP(hostname); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, hostname)
+		}
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+		nil
+}
+
+// WithSelfSignedCertificate uses a self-signed certificate for the provided
+// hostnames as the app's default certificate. The certificate is stored as
+// cert.pem and key.pem in storeDir, which is created if it doesn't exist.
+//
+// The first time this is used with a given storeDir, a new certificate that is
+// valid for SelfSignedCertificateValidity is generated. After that, the stored
+// certificate is reused, regardless of the hostnames provided, so that clients
+// that have pinned it continue to trust the app.
+func WithSelfSignedCertificate(hostnames []string, storeDir string) AppConfigFunction {
+	return func(app *App) error {
+		certFile := filepath.Join(storeDir, "cert.pem")
+		keyFile := filepath.Join(storeDir, "key.pem")
+
+		certExists, err := fileExists(certFile)
+		if err != nil {
+			return err
+		}
+		keyExists, err := fileExists(keyFile)
+		if err != nil {
+			return err
+		}
+
+		if certExists && !keyExists {
+			return fmt.Errorf("mercury: %s exists but %s doesn't", certFile, keyFile)
+		}
+
+		// The key is stored before the certificate, so a key without a
+		// certificate is left behind if storing the certificate fails. The
+		// key was never used in that case, so both are regenerated.
+		if !certExists {
+			certPEM, keyPEM, err := GenerateSelfSignedCertificate(hostnames, SelfSignedCertificateValidity)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(storeDir, 0700); err != nil {
+				return err
+			}
+			if err := writeFileAtomic(keyFile, keyPEM, 0600); err != nil {
+				return err
+			}
+			if err := writeFileAtomic(certFile, certPEM, 0644); err != nil {
+				return err
+			}
+		}
+
+		return app.certificates.loadFiles("", certFile, keyFile)
+	}
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory as
+// name, then renames it to name, so that name is never left partially written.
+func writeFileAtomic(name string, data []byte, perm fs.FileMode) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	if err := f.Chmod(perm); err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+func fileExists(name string) (bool, error) {
+	_, err := os.Stat(name)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return false, err
+}
@@ -0,0 +1,87 @@
+package mercury
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWithSelfSignedCertificate(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "certs")
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	app := newTestApp(t, WithSelfSignedCertificate([]string{"example.com", "127.0.0.1"}, dir))
+	if got := currentCertificateCommonName(t, app); got != "example.com" {
+		t.Errorf("app certificate common name = %v, want example.com", got)
+	}
+
+	if info, err := os.Stat(keyFile); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Errorf("key file permissions = %v, want 0600", info.Mode().Perm())
+	}
+
+	generated, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(generated)
+	if block == nil {
+		t.Fatal("stored certificate is not PEM-encoded")
+	}
+	parsed, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.DNSNames, []string{"example.com"}) {
+		t.Errorf("DNS names = %v, want [example.com]", parsed.DNSNames)
+	}
+	if len(parsed.IPAddresses) != 1 || !parsed.IPAddresses[0].Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("IP addresses = %v, want [127.0.0.1]", parsed.IPAddresses)
+	}
+	if time.Until(parsed.NotAfter) < 50*365*24*time.Hour {
+		t.Errorf("certificate expires at %v, want a long validity", parsed.NotAfter)
+	}
+
+	// a second app using the same directory reuses the certificate
+	app = newTestApp(t, WithSelfSignedCertificate([]string{"other.example"}, dir))
+	if got := currentCertificateCommonName(t, app); got != "example.com" {
+		t.Errorf("reused certificate common name = %v, want example.com", got)
+	}
+	reused, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(generated, reused) {
+		t.Error("certificate was regenerated instead of being reused")
+	}
+
+	// a key without a certificate is left behind if storing the certificate
+	// fails, and is replaced
+	if err := os.Remove(certFile); err != nil {
+		t.Fatal(err)
+	}
+	app = newTestApp(t, WithSelfSignedCertificate([]string{"other.example"}, dir))
+	if got := currentCertificateCommonName(t, app); got != "other.example" {
+		t.Errorf("regenerated certificate common name = %v, want other.example", got)
+	}
+	if entries, err := os.ReadDir(dir); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 2 {
+		t.Errorf("store directory contains %d files, want 2", len(entries))
+	}
+
+	// a missing key is an error rather than a reason to regenerate
+	if err := os.Remove(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(WithSelfSignedCertificate([]string{"example.com"}, dir)); err == nil {
+		t.Error("New() error = nil, want error when only the certificate exists")
+	}
+}
@@ -0,0 +1,146 @@
+package mercury
+
+import (
+	"errors"
+	"io/fs"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/codemicro/mercury/gemtext"
+)
+
+// StaticConfig controls the behaviour of a handler created with Static.
+type StaticConfig struct {
+	// IndexFiles are the names of files that are served when a directory is
+	// requested, in order of preference. If this is nil, index.gmi is used.
+	IndexFiles []string
+	// DirectoryListing enables generating a gemtext listing of a directory's
+	// contents when it's requested and it has no index file.
+	DirectoryListing bool
+	// ShowHidden allows files and directories whose names start with a period
+	// to be served and shown in directory listings.
+	ShowHidden bool
+}
+
+// Static creates a handler function that serves files from the provided
+// filesystem. The MIME type of each file is inferred from its extension.
+//
+// If the handler is registered on a path ending with a wildcard, such as
+// /files/*path, the contents of the wildcard are used as the path to the file
+// to serve. Otherwise, the entire request path is used.
+func Static(root fs.FS, conf StaticConfig) HandlerFunction {
+	if conf.IndexFiles == nil {
+		conf.IndexFiles = []string{"index.gmi"}
+	}
+
+	return func(ctx *Ctx) error {
+		requestPath := ctx.GetRequestURL().Path
+
+		name, isWildcard := ctx.getWildcardValue()
+		if !isWildcard {
+			name = requestPath
+		}
+
+		name, ok := cleanStaticPath(name)
+		if !ok || (!conf.ShowHidden && isHiddenPath(name)) {
+			return NewError("Not found", StatusNotFound)
+		}
+
+		info, err := fs.Stat(root, name)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return NewError("Not found", StatusNotFound)
+			}
+			return err
+		}
+
+		if !info.IsDir() {
+			return ctx.SetBodyFromFS(root, name)
+		}
+
+		if !strings.HasSuffix(requestPath, "/") {
+			// relative links in index files and directory listings only work
+			// when the URL ends in a slash
+			ctx.SetStatus(StatusPermanentRedirect)
+			return ctx.SetMeta(directoryRedirectTarget(requestPath))
+		}
+
+		for _, indexFile := range conf.IndexFiles {
+			indexName := path.Join(name, indexFile)
+			if info, err := fs.Stat(root, indexName); err == nil && !info.IsDir() {
+				return ctx.SetBodyFromFS(root, indexName)
+			}
+		}
+
+		if !conf.DirectoryListing {
+			return NewError("Not found", StatusNotFound)
+		}
+
+		entries, err := fs.ReadDir(root, name)
+		if err != nil {
+			return err
+		}
+
+		var b gemtext.Builder
+		b.Heading(1, "Index of "+requestPath)
+		b.Blank()
+		if name != "." {
+			b.Link("../", "..")
+		}
+		for _, entry := range entries {
+			entryName := entry.Name()
+			if !conf.ShowHidden && strings.HasPrefix(entryName, ".") {
+				continue
+			}
+			link := url.PathEscape(entryName)
+			if entry.IsDir() {
+				link += "/"
+				entryName += "/"
+			}
+			b.Link(link, entryName)
+		}
+
+		ctx.SetGemtext(b.Document())
+		return nil
+	}
+}
+
+// cleanStaticPath converts a URL path into a path that can be used with an
+// fs.FS, returning false if that's not possible. Because the path is cleaned
+// as if it were absolute, it can never refer to anything outside of the root
+// of the filesystem.
+func cleanStaticPath(p string) (string, bool) {
+	if strings.Contains(p, "\\") {
+		return "", false
+	}
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if p == "" {
+		p = "."
+	}
+	return p, fs.ValidPath(p)
+}
+
+// isHiddenPath returns true if any component of the path starts with a period.
+func isHiddenPath(p string) bool {
+	if p == "." {
+		return false
+	}
+	for _, component := range strings.Split(p, "/") {
+		if strings.HasPrefix(component, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// directoryRedirectTarget returns a URL relative to the request path that
+// points to the same path with a trailing slash. A relative URL is used so
+// that the redirect works regardless of where the handler is mounted.
+func directoryRedirectTarget(requestPath string) string {
+	base := requestPath[strings.LastIndex(requestPath, "/")+1:]
+	if base == "" {
+		return "./"
+	}
+	return url.PathEscape(base) + "/"
+}
@@ -0,0 +1,57 @@
+package mercury
+
+import (
+	"io"
+	"io/fs"
+	"net"
+	"testing"
+	"testing/fstest"
+)
+
+func TestStatic(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.gmi":           &fstest.MapFile{Data: []byte("# Home\n")},
+		"notes.txt":           &fstest.MapFile{Data: []byte("some notes")},
+		"docs/guide.gmi":      &fstest.MapFile{Data: []byte("# Guide\n")},
+		"docs/sub dir/a.gmi":  &fstest.MapFile{Data: []byte("a")},
+		"docs/.secret":        &fstest.MapFile{Data: []byte("secret")},
+		".hidden/file.gmi":    &fstest.MapFile{Data: []byte("hidden")},
+		"empty":               &fstest.MapFile{Mode: fs.ModeDir},
+		"unlisted/index.html": &fstest.MapFile{Data: []byte("<html>")},
+	}
+
+	app := newTestApp(t)
+	app.Add("/files/*path", Static(fsys, StaticConfig{DirectoryListing: true}))
+	app.Add("/unlisted/*path", Static(fsys, StaticConfig{IndexFiles: []string{"index.html"}}))
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"rootIndex", "/files/", "20 text/gemini\r\n# Home\n"},
+		{"rootRedirect", "/files", "31 files/\r\n"},
+		{"file", "/files/notes.txt", "20 text/plain\r\nsome notes"},
+		{"directoryRedirect", "/files/docs", "31 docs/\r\n"},
+		{"directoryListing", "/files/docs/", "20 text/gemini\r\n# Index of /files/docs/\n\n=> ../ ..\n=> guide.gmi guide.gmi\n=> sub%20dir/ sub dir/\n"},
+		{"escapedName", "/files/docs/sub%20dir/a.gmi", "20 text/gemini\r\na"},
+		{"hiddenFile", "/files/docs/.secret", "51 Not found\r\n"},
+		{"hiddenDirectory", "/files/.hidden/file.gmi", "51 Not found\r\n"},
+		{"traversal", "/files/../files/../../notes.txt", "20 text/plain\r\nsome notes"},
+		{"encodedTraversal", "/files/%2e%2e/%2e%2e/etc/passwd", "51 Not found\r\n"},
+		{"missing", "/files/missing.gmi", "51 Not found\r\n"},
+		{"customIndex", "/unlisted/unlisted/", "20 text/html; charset=utf-8\r\n<html>"},
+		{"listingDisabled", "/unlisted/empty/", "51 Not found\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := doTestRequest(t, app, func(conn net.Conn) error {
+				_, err := io.WriteString(conn, "gemini://localhost"+tt.path+"\r\n")
+				return err
+			})
+			if got != tt.want {
+				t.Errorf("response = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,8 +1,11 @@
 package mercury
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
+	"fmt"
+	"io"
 	"net/url"
 	"strconv"
 	"strings"
@@ -15,6 +18,43 @@ var (
 	errorMalformedRequest      = NewError("Malformed request", StatusBadRequest)
 )
 
+// maxRequestLength is the maximum length of a request URL plus the CRLF that
+// terminates it.
+const maxRequestLength = 1024 + 2
+
+// readRequest reads from r until a CRLF has been read, returning everything
+// that was read. Requests may arrive split across any number of reads.
+//
+// If no CRLF is found within maxRequestLength bytes, or r returns io.EOF
+// before a CRLF is found, an *Error is returned.
+func readRequest(r io.Reader) ([]byte, error) {
+	buf := make([]byte, maxRequestLength)
+	var n int
+	for n < len(buf) {
+		m, err := r.Read(buf[n:])
+
+		// a CR at the end of the previous read may be followed by an LF at
+		// the start of this one
+		searchFrom := n - 1
+		if searchFrom < 0 {
+			searchFrom = 0
+		}
+		n += m
+
+		if bytes.Contains(buf[searchFrom:n], []byte{'\r', '\n'}) {
+			return buf[:n], nil
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, errorMalformedRequest
+			}
+			return nil, err
+		}
+	}
+	return nil, errorRequestURLTooLong
+}
+
 type request struct {
 	URL            *url.URL
 	pathComponents []string
@@ -55,38 +95,240 @@ func parseRequest(x []byte) (*request, error) {
 	}, nil
 }
 
+// relativeTo returns a copy of the request with the first prefixLength
+// components of its path removed.
+func (r *request) relativeTo(prefixLength int) *request {
+	u := *r.URL
+
+	components := splitPath(u.Path)
+	if prefixLength > len(components) {
+		prefixLength = len(components)
+	}
+	u.Path = strings.Join(append([]string{""}, components[prefixLength:]...), "/")
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.RawPath = ""
+
+	return &request{
+		URL:            &u,
+		pathComponents: append([]string{""}, r.pathComponents[prefixLength:]...),
+	}
+}
+
+var (
+	errorEncodeURLTooLong = errors.New("mercury: request URL is longer than 1024 bytes")
+	errorEncodeNoScheme   = errors.New("mercury: request URL has no scheme")
+)
+
+// EncodeRequest encodes a request for the provided URL as it is sent to a
+// server.
+func EncodeRequest(u *url.URL) ([]byte, error) {
+	if u.Scheme == "" {
+		return nil, errorEncodeNoScheme
+	}
+
+	rawURL := u.String()
+	if len(rawURL) > 1024 {
+		return nil, errorEncodeURLTooLong
+	}
+
+	return append([]byte(rawURL), '\r', '\n'), nil
+}
+
 var (
 	errorResponseMetaTooLong = errors.New("mercury: meta too long")
 	errorImpossibleResponse  = errors.New("mercury: impossible response")
 )
 
-type response struct {
+// Response is a Gemini response. Handlers build up the response for a request
+// through its Ctx, which also provides access to the Response itself using
+// (*Ctx).Response.
+type Response struct {
 	status  Status
 	meta    []byte
 	content []byte
+	// bodyReader is copied to the client after content, if set
+	bodyReader io.Reader
 }
 
-func (r *response) Encode() ([]byte, error) {
-	if len(r.meta) > 1024 {
-		return nil, errorResponseMetaTooLong
+// ReadResponse reads a complete response from r, including its body.
+func ReadResponse(r io.Reader) (*Response, error) {
+	br := bufio.NewReader(r)
+
+	status, meta, err := ReadResponseHeader(br)
+	if err != nil {
+		return nil, err
 	}
 
-	if bytes.HasPrefix(r.meta, []byte("\uFFFF")) {
+	content, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	if status/10 != 2 && len(content) != 0 {
 		return nil, errorImpossibleResponse
 	}
+	if len(content) == 0 {
+		content = nil
+	}
+
+	return &Response{
+		status:  status,
+		meta:    []byte(meta),
+		content: content,
+	}, nil
+}
+
+// ParseResponse parses a complete response in the form produced by
+// (*Response).Encode.
+func ParseResponse(b []byte) (*Response, error) {
+	return ReadResponse(bytes.NewReader(b))
+}
+
+// Status returns the status code of the response.
+func (r *Response) Status() Status {
+	return r.status
+}
 
+// SetStatus sets the status code of the response.
+func (r *Response) SetStatus(status Status) {
+	r.status = status
+}
+
+// Meta returns the meta field of the response.
+func (r *Response) Meta() string {
+	return string(r.meta)
+}
+
+// SetMeta sets the meta field of the response, which cannot be longer than
+// 1024 bytes.
+func (r *Response) SetMeta(meta string) error {
+	if len(meta) > 1024 {
+		return fmt.Errorf("mercury: meta too long (len %d > 1024)", len(meta))
+	}
+	r.meta = []byte(meta)
+	return nil
+}
+
+// MediaType parses the meta field of a response with a success status code.
+func (r *Response) MediaType() (MediaType, error) {
+	if r.status/10 != 2 {
+		return MediaType{}, fmt.Errorf("mercury: response with status %d has no media type", r.status)
+	}
+	return ParseMediaType(string(r.meta))
+}
+
+// Body returns the response body. This does not include any content that is
+// read from the body reader.
+func (r *Response) Body() []byte {
+	return r.content
+}
+
+// SetBody sets the response body, replacing the body reader if there is one.
+func (r *Response) SetBody(body []byte) {
+	r.closeBodyReader()
+	r.content = body
+}
+
+// BodyReader returns the reader that the response body is copied from, if
+// there is one.
+func (r *Response) BodyReader() io.Reader {
+	return r.bodyReader
+}
+
+// SetBodyReader sets a reader that the response body is copied from when the
+// response is sent. If the reader is also an io.Closer, it will be closed
+// once the response has been sent or the body is replaced.
+func (r *Response) SetBodyReader(reader io.Reader) {
+	r.closeBodyReader()
+	r.content = nil
+	r.bodyReader = reader
+}
+
+// closeBodyReader closes and removes the body reader, if there is one.
+func (r *Response) closeBodyReader() {
+	if c, ok := r.bodyReader.(io.Closer); ok {
+		_ = c.Close()
+	}
+	r.bodyReader = nil
+}
+
+// Encode encodes the response as it is sent to a client. Any content that is
+// read from the body reader is not included.
+func (r *Response) Encode() ([]byte, error) {
 	if r.status/10 != 2 { // 2 denotes the success range of codes
-		if len(r.content) != 0 {
+		if len(r.content) != 0 || r.bodyReader != nil {
 			return nil, errorImpossibleResponse
 		}
 	}
 
+	b, err := r.encodeHeader()
+	if err != nil {
+		return nil, err
+	}
+	return append(b, r.content...), nil
+}
+
+// encodeHeader encodes only the status and meta line of the response.
+func (r *Response) encodeHeader() ([]byte, error) {
+	if len(r.meta) > 1024 {
+		return nil, errorResponseMetaTooLong
+	}
+
+	if bytes.HasPrefix(r.meta, []byte("\uFFFF")) {
+		return nil, errorImpossibleResponse
+	}
+
 	var b []byte
 	b = strconv.AppendInt(b, int64(r.status), 10)
 	b = append(b, ' ')
 	b = append(b, r.meta...)
 	b = append(b, '\r', '\n')
-	b = append(b, r.content...)
 
 	return b, nil
 }
+
+var (
+	errorMalformedResponseHeader = errors.New("mercury: malformed response header")
+	errorInvalidResponseStatus   = errors.New("mercury: invalid response status")
+)
+
+// maxResponseHeaderLength is the maximum length of a response header, made up
+// of a two digit status, a space, up to 1024 bytes of meta and a CRLF.
+const maxResponseHeaderLength = 2 + 1 + 1024 + 2
+
+// ReadResponseHeader reads the status and meta line of a response from r,
+// leaving r positioned at the start of the response body.
+func ReadResponseHeader(r *bufio.Reader) (Status, string, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return 0, "", errorMalformedResponseHeader
+			}
+			return 0, "", err
+		}
+		line = append(line, b)
+
+		if b == '\n' {
+			break
+		}
+		if len(line) == maxResponseHeaderLength {
+			return 0, "", errorResponseMetaTooLong
+		}
+	}
+
+	header, found := bytes.CutSuffix(line, []byte{'\r', '\n'})
+	if !found {
+		return 0, "", errorMalformedResponseHeader
+	}
+
+	statusBytes, meta, _ := bytes.Cut(header, []byte{' '})
+	if len(statusBytes) != 2 || statusBytes[0] < '1' || statusBytes[0] > '6' || statusBytes[1] < '0' || statusBytes[1] > '9' {
+		return 0, "", errorInvalidResponseStatus
+	}
+	status, _ := strconv.Atoi(string(statusBytes))
+
+	return Status(status), string(meta), nil
+}
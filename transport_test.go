@@ -1,9 +1,14 @@
 package mercury
 
 import (
+	"bufio"
+	"bytes"
+	"io"
 	"net/url"
 	"reflect"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func mustParseURL(x string) *url.URL {
@@ -40,6 +45,38 @@ func Test_parseRequsest(t *testing.T) {
 	}
 }
 
+func Test_readRequest(t *testing.T) {
+	longURL := "gemini://example.com/" + strings.Repeat("a", 1024-len("gemini://example.com/"))
+
+	tests := []struct {
+		name    string
+		reader  io.Reader
+		want    string
+		wantErr error
+	}{
+		{"normal", strings.NewReader("gemini://example.com\r\n"), "gemini://example.com\r\n", nil},
+		{"oneByteAtATime", iotest.OneByteReader(strings.NewReader("gemini://example.com\r\n")), "gemini://example.com\r\n", nil},
+		{"halfReads", iotest.HalfReader(strings.NewReader("gemini://example.com\r\n")), "gemini://example.com\r\n", nil},
+		{"maximumLength", iotest.OneByteReader(strings.NewReader(longURL + "\r\n")), longURL + "\r\n", nil},
+		{"tooLong", strings.NewReader(longURL + "a\r\n"), "", errorRequestURLTooLong},
+		{"noCRLF", strings.NewReader("gemini://example.com"), "", errorMalformedRequest},
+		{"onlyCR", iotest.OneByteReader(strings.NewReader("gemini://example.com\r")), "", errorMalformedRequest},
+		{"readError", iotest.TimeoutReader(iotest.OneByteReader(strings.NewReader("gemini://example.com\r\n"))), "", iotest.ErrTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readRequest(tt.reader)
+			if err != tt.wantErr {
+				t.Errorf("readRequest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !bytes.Equal(got, []byte(tt.want)) {
+				t.Errorf("readRequest() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 func Test_response_Encode(t *testing.T) {
 	type fields struct {
 		status  Status
@@ -59,7 +96,7 @@ func Test_response_Encode(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			r := &response{
+			r := &Response{
 				status:  tt.fields.status,
 				meta:    tt.fields.meta,
 				content: tt.fields.content,
@@ -75,3 +112,107 @@ func Test_response_Encode(t *testing.T) {
 		})
 	}
 }
+
+func TestEncodeRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		want    string
+		wantErr error
+	}{
+		{"normal", "gemini://example.com/hello?world", "gemini://example.com/hello?world\r\n", nil},
+		{"maximumLength", "gemini://example.com/" + strings.Repeat("a", 1003), "gemini://example.com/" + strings.Repeat("a", 1003) + "\r\n", nil},
+		{"tooLong", "gemini://example.com/" + strings.Repeat("a", 1004), "", errorEncodeURLTooLong},
+		{"noScheme", "example.com/hello", "", errorEncodeNoScheme},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EncodeRequest(mustParseURL(tt.url))
+			if err != tt.wantErr {
+				t.Errorf("EncodeRequest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if string(got) != tt.want {
+				t.Errorf("EncodeRequest() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadResponseHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		wantStatus Status
+		wantMeta   string
+		wantRest   string
+		wantErr    error
+	}{
+		{"normal", "20 text/gemini\r\n# Hello", StatusSuccess, "text/gemini", "# Hello", nil},
+		{"emptyMeta", "20 \r\n", StatusSuccess, "", "", nil},
+		{"noSpace", "20\r\n", StatusSuccess, "", "", nil},
+		{"metaWithSpaces", "10 What is your name?\r\n", StatusInput, "What is your name?", "", nil},
+		{"maximumLength", "51 " + strings.Repeat("a", 1024) + "\r\n", StatusNotFound, strings.Repeat("a", 1024), "", nil},
+		{"tooLong", "51 " + strings.Repeat("a", 1025) + "\r\n", 0, "", "", errorResponseMetaTooLong},
+		{"onlyLF", "20 text/gemini\n", 0, "", "", errorMalformedResponseHeader},
+		{"noCRLF", "20 text/gemini", 0, "", "", errorMalformedResponseHeader},
+		{"oneDigitStatus", "2 text/gemini\r\n", 0, "", "", errorInvalidResponseStatus},
+		{"outOfRangeStatus", "70 text/gemini\r\n", 0, "", "", errorInvalidResponseStatus},
+		{"nonNumericStatus", "2x text/gemini\r\n", 0, "", "", errorInvalidResponseStatus},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(iotest.HalfReader(strings.NewReader(tt.input)))
+			status, meta, err := ReadResponseHeader(r)
+			if err != tt.wantErr {
+				t.Errorf("ReadResponseHeader() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if status != tt.wantStatus || meta != tt.wantMeta {
+				t.Errorf("ReadResponseHeader() got = %d %q, want %d %q", status, meta, tt.wantStatus, tt.wantMeta)
+			}
+			if rest, _ := io.ReadAll(r); err == nil && string(rest) != tt.wantRest {
+				t.Errorf("ReadResponseHeader() left %q unread, want %q", rest, tt.wantRest)
+			}
+		})
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		wantStatus Status
+		wantMeta   string
+		wantBody   string
+		wantErr    bool
+	}{
+		{"success", "20 text/gemini\r\n# Hello\n", StatusSuccess, "text/gemini", "# Hello\n", false},
+		{"emptyBody", "20 text/plain\r\n", StatusSuccess, "text/plain", "", false},
+		{"notFound", "51 Not found\r\n", StatusNotFound, "Not found", "", false},
+		{"bodyWithFailure", "51 Not found\r\nHello", 0, "", "", true},
+		{"malformedHeader", "20 text/gemini\n", 0, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseResponse([]byte(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseResponse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Status() != tt.wantStatus || got.Meta() != tt.wantMeta || string(got.Body()) != tt.wantBody {
+				t.Errorf("ParseResponse() got = %d %q %q, want %d %q %q", got.Status(), got.Meta(), got.Body(), tt.wantStatus, tt.wantMeta, tt.wantBody)
+			}
+
+			encoded, err := got.Encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(encoded) != tt.input {
+				t.Errorf("Encode() got = %q, want %q", encoded, tt.input)
+			}
+		})
+	}
+}
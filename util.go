@@ -3,6 +3,8 @@ package mercury
 import (
 	"crypto"
 	"crypto/x509"
+	"mime"
+	"path"
 	"strings"
 )
 
@@ -18,6 +20,42 @@ func splitPath(path string) []string {
 	return strings.Split(path, "/")
 }
 
+// mimeTypeOverrides contains MIME types that aren't included in, or should take
+// precedence over, the system's MIME type tables.
+var mimeTypeOverrides = map[string]string{
+	".gmi":    "text/gemini",
+	".gemini": "text/gemini",
+	".txt":    "text/plain",
+	".md":     "text/markdown",
+}
+
+// mimeTypeByExtension returns the MIME type to use for a file based on its
+// extension, falling back to application/octet-stream if nothing better is
+// known.
+func mimeTypeByExtension(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if t, found := mimeTypeOverrides[ext]; found {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// joinPath appends a path onto a prefix such that there is always exactly one
+// slash between the two.
+func joinPath(prefix, path string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if path == "/" && prefix != "" {
+		return prefix
+	}
+	return prefix + path
+}
+
 // FingerprintCertificate computes a SHA1 hash of the raw certificate bytes.
 func FingerprintCertificate(cert *x509.Certificate) []byte {
 	return FingerprintCertificateWithHash(cert, crypto.SHA1)
@@ -26,5 +64,6 @@ func FingerprintCertificate(cert *x509.Certificate) []byte {
 // FingerprintCertificateWithHash computes a hash of a given type from the raw certificate bytes.
 func FingerprintCertificateWithHash(cert *x509.Certificate, hashType crypto.Hash) []byte {
 	hf := hashType.New()
-	return hf.Sum(cert.Raw)
+	hf.Write(cert.Raw)
+	return hf.Sum(nil)
 }
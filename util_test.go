@@ -1,6 +1,10 @@
 package mercury
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
 	"reflect"
 	"testing"
 )
@@ -22,3 +26,56 @@ func Test_splitPath(t *testing.T) {
 		})
 	}
 }
+
+func Test_joinPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		path   string
+		want   string
+	}{
+		{"normal", "/admin", "/users", "/admin/users"},
+		{"noLeadingSlash", "/admin", "users", "/admin/users"},
+		{"trailingSlashOnPrefix", "/admin/", "/users", "/admin/users"},
+		{"rootPath", "/admin", "/", "/admin"},
+		{"rootPrefix", "/", "/users", "/users"},
+		{"rootPrefixAndPath", "/", "/", "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := joinPath(tt.prefix, tt.path); got != tt.want {
+				t.Errorf("joinPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_mimeTypeByExtension(t *testing.T) {
+	tests := []struct {
+		name string
+		arg  string
+		want string
+	}{
+		{"gemtext", "index.gmi", "text/gemini"},
+		{"gemtextLongExtension", "dir/index.GEMINI", "text/gemini"},
+		{"plainText", "notes.txt", "text/plain"},
+		{"png", "image.png", "image/png"},
+		{"unknown", "data.mercuryunknown", "application/octet-stream"},
+		{"noExtension", "README", "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mimeTypeByExtension(tt.arg); got != tt.want {
+				t.Errorf("mimeTypeByExtension() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFingerprintCertificateWithHash(t *testing.T) {
+	cert := &x509.Certificate{Raw: []byte("not really a certificate")}
+	want := sha256.Sum256(cert.Raw)
+	if got := FingerprintCertificateWithHash(cert, crypto.SHA256); !bytes.Equal(got, want[:]) {
+		t.Errorf("FingerprintCertificateWithHash() = %x, want %x", got, want)
+	}
+}